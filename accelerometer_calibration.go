@@ -0,0 +1,225 @@
+package lsm303
+
+import (
+	"errors"
+	"math"
+	"periph.io/x/periph/conn/physic"
+)
+
+// Corrects accelerometer readings for zero-g offset, sensitivity and
+// cross-axis errors
+type AccelerometerCalibration struct {
+	// Zero-g offset, subtracted from every reading
+	Offset [3]physic.Force `json:"offset"`
+	// Multiplied with every reading after the offset is removed. The diagonal
+	// is the per-axis scale, and the rest are the cross-axis terms. The zero
+	// matrix is treated as the identity, so only the offset is applied.
+	Scale [3][3]float64 `json:"scale"`
+}
+
+// Applies the correction to a reading
+func (calibration *AccelerometerCalibration) Apply(x, y, z physic.Force) (physic.Force, physic.Force, physic.Force) {
+	x -= calibration.Offset[0]
+	y -= calibration.Offset[1]
+	z -= calibration.Offset[2]
+	if calibration.Scale == ([3][3]float64{}) {
+		return x, y, z
+	}
+	corrected := multiply3(calibration.Scale, [3]float64{float64(x), float64(y), float64(z)})
+	return physic.Force(math.Round(corrected[0])), physic.Force(math.Round(corrected[1])), physic.Force(math.Round(corrected[2]))
+}
+
+// Sets the calibration that Sense applies to every reading. Pass nil to
+// disable it.
+func (accelerometer *Accelerometer) SetCalibration(calibration *AccelerometerCalibration) {
+	accelerometer.calibration = calibration
+}
+
+// How close the corrected samples are to 1 G. The samples should have been
+// taken while the device was still, so the only acceleration was gravity.
+type AccelerometerCalibrationQuality struct {
+	// The RMS difference between the magnitude of the corrected samples and
+	// 1 G
+	RMSError physic.Force
+	// The largest difference
+	MaxError physic.Force
+	// How many samples or positions the calibration was computed from
+	Samples int
+}
+
+// Collects accelerometer samples and computes a calibration from them. There
+// are two ways to calibrate:
+//
+// Six-position: hold the device still with each axis pointing up and down in
+// turn, and add the readings with AddPosition. NextPosition says which
+// position is still needed.
+//
+// Ellipsoid: hold the device still in as many different orientations as
+// possible and add the readings with Add. This doesn't need the positions to
+// be aligned with the axes, but needs more samples.
+type AccelerometerCalibrator struct {
+	// Sums of the readings in G, indexed by Orientation - 1
+	positionSums   [6][3]float64
+	positionCounts [6]int
+	// Samples for the ellipsoid fit, in G
+	samples [][3]float64
+}
+
+func NewAccelerometerCalibrator() *AccelerometerCalibrator {
+	return &AccelerometerCalibrator{}
+}
+
+func toG(x, y, z physic.Force) [3]float64 {
+	gravity := float64(physic.EarthGravity)
+	return [3]float64{float64(x) / gravity, float64(y) / gravity, float64(z) / gravity}
+}
+
+// Returns the gravity vector that should be read in the orientation
+func expectedGravity(orientation Orientation) [3]float64 {
+	return [...][3]float64{
+		ORIENTATION_X_UP:      {1, 0, 0},
+		ORIENTATION_X_DOWN:    {-1, 0, 0},
+		ORIENTATION_Y_UP:      {0, 1, 0},
+		ORIENTATION_Y_DOWN:    {0, -1, 0},
+		ORIENTATION_FACE_UP:   {0, 0, 1},
+		ORIENTATION_FACE_DOWN: {0, 0, -1},
+	}[orientation]
+}
+
+// Adds a reading taken while the device was held still in the orientation.
+// The reading must be uncorrected, e.g. from SenseSample().Acceleration(),
+// because Sense applies the current calibration. Several readings can be added
+// for each position, and they're averaged.
+// Returns an error if the reading doesn't look like it was taken in that
+// orientation.
+func (calibrator *AccelerometerCalibrator) AddPosition(orientation Orientation, x, y, z physic.Force) error {
+	if orientation <= ORIENTATION_UNKNOWN || orientation > ORIENTATION_FACE_DOWN {
+		return errors.New("Unknown accelerometer calibration position")
+	}
+	reading := toG(x, y, z)
+	expected := expectedGravity(orientation)
+	// The reading should be mostly along the expected axis, even before
+	// calibration
+	if reading[0]*expected[0]+reading[1]*expected[1]+reading[2]*expected[2] < 0.8 {
+		return errors.New("Accelerometer isn't in position " + orientation.String())
+	}
+	index := orientation - 1
+	for i := range reading {
+		calibrator.positionSums[index][i] += reading[i]
+	}
+	calibrator.positionCounts[index]++
+	return nil
+}
+
+// Returns the next position that needs a reading for the six-position
+// calibration, or false if they all have one
+func (calibrator *AccelerometerCalibrator) NextPosition() (Orientation, bool) {
+	for i, count := range calibrator.positionCounts {
+		if count == 0 {
+			return Orientation(i + 1), true
+		}
+	}
+	return ORIENTATION_UNKNOWN, false
+}
+
+// Computes the calibration from the six positions with least squares
+func (calibrator *AccelerometerCalibrator) SixPositionCalibration() (AccelerometerCalibration, AccelerometerCalibrationQuality, error) {
+	next, missing := calibrator.NextPosition()
+	if missing {
+		return AccelerometerCalibration{}, AccelerometerCalibrationQuality{}, errors.New("No reading for accelerometer position " + next.String())
+	}
+	var averages [6][3]float64
+	for position := range averages {
+		for i := range averages[position] {
+			averages[position][i] = calibrator.positionSums[position][i] / float64(calibrator.positionCounts[position])
+		}
+	}
+
+	// Each row of the correction is fitted separately:
+	// expected[i] = scale[i] . reading + bias[i]
+	var scale [3][3]float64
+	var bias [3]float64
+	for i := range scale {
+		normal := make([][]float64, 4)
+		for j := range normal {
+			normal[j] = make([]float64, 5)
+		}
+		for position, average := range averages {
+			row := [...]float64{average[0], average[1], average[2], 1}
+			expected := expectedGravity(Orientation(position + 1))[i]
+			for j := range row {
+				for k := range row {
+					normal[j][k] += row[j] * row[k]
+				}
+				normal[j][4] += row[j] * expected
+			}
+		}
+		parameters, err := solveLinear(normal)
+		if err != nil {
+			return AccelerometerCalibration{}, AccelerometerCalibrationQuality{}, errors.New("Accelerometer positions are degenerate")
+		}
+		copy(scale[i][:], parameters[:3])
+		bias[i] = parameters[3]
+	}
+
+	// scale * reading + bias = scale * (reading - offset)
+	inverse, err := invert3(scale)
+	if err != nil {
+		return AccelerometerCalibration{}, AccelerometerCalibrationQuality{}, errors.New("Accelerometer positions are degenerate")
+	}
+	offset := multiply3(inverse, bias)
+	var center [3]float64
+	for i := range offset {
+		center[i] = -offset[i]
+	}
+	calibration := newAccelerometerCalibration(center, scale)
+	return calibration, accelerometerQuality(averages[:], center, scale), nil
+}
+
+// Adds an uncorrected reading taken while the device was held still, in any
+// orientation
+func (calibrator *AccelerometerCalibrator) Add(x, y, z physic.Force) {
+	calibrator.samples = append(calibrator.samples, toG(x, y, z))
+}
+
+func (calibrator *AccelerometerCalibrator) Len() int {
+	return len(calibrator.samples)
+}
+
+// Computes the calibration with a least squares ellipsoid fit of the samples
+// from Add. The correction maps the samples onto a sphere of radius 1 G.
+func (calibrator *AccelerometerCalibrator) EllipsoidCalibration() (AccelerometerCalibration, AccelerometerCalibrationQuality, error) {
+	center, shape, err := fitEllipsoid(calibrator.samples)
+	if err != nil {
+		return AccelerometerCalibration{}, AccelerometerCalibrationQuality{}, err
+	}
+	scale := ellipsoidCorrection(shape, 1)
+	calibration := newAccelerometerCalibration(center, scale)
+	return calibration, accelerometerQuality(calibrator.samples, center, scale), nil
+}
+
+// Converts the offset from G
+func newAccelerometerCalibration(center [3]float64, scale [3][3]float64) AccelerometerCalibration {
+	calibration := AccelerometerCalibration{Scale: scale}
+	for i := range center {
+		calibration.Offset[i] = physic.Force(math.Round(center[i] * float64(physic.EarthGravity)))
+	}
+	return calibration
+}
+
+func accelerometerQuality(readings [][3]float64, center [3]float64, scale [3][3]float64) AccelerometerCalibrationQuality {
+	sum := 0.0
+	maximum := 0.0
+	for _, reading := range readings {
+		corrected := multiply3(scale, [3]float64{reading[0] - center[0], reading[1] - center[1], reading[2] - center[2]})
+		difference := math.Abs(math.Sqrt(corrected[0]*corrected[0]+corrected[1]*corrected[1]+corrected[2]*corrected[2]) - 1)
+		sum += difference * difference
+		maximum = math.Max(maximum, difference)
+	}
+	gravity := float64(physic.EarthGravity)
+	return AccelerometerCalibrationQuality{
+		RMSError: physic.Force(math.Round(math.Sqrt(sum/float64(len(readings))) * gravity)),
+		MaxError: physic.Force(math.Round(maximum * gravity)),
+		Samples:  len(readings),
+	}
+}
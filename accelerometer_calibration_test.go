@@ -0,0 +1,140 @@
+package lsm303
+
+import (
+	"encoding/binary"
+	"math"
+	"periph.io/x/periph/conn/i2c"
+	"periph.io/x/periph/conn/i2c/i2ctest"
+	"periph.io/x/periph/conn/mmr"
+	"periph.io/x/periph/conn/physic"
+	"testing"
+)
+
+// An accelerometer with offset, scale and cross-axis errors
+var accelerometerDistortion = [3][3]float64{{1.03, 0.01, -0.02}, {0.015, 0.97, 0.01}, {-0.01, 0.02, 1.05}}
+var accelerometerOffset = [3]float64{0.05, -0.03, 0.08}
+
+func distortAcceleration(gravity [3]float64) [3]physic.Force {
+	reading := multiply3(accelerometerDistortion, gravity)
+	var forces [3]physic.Force
+	for i := range reading {
+		forces[i] = physic.Force((reading[i] + accelerometerOffset[i]) * float64(physic.EarthGravity))
+	}
+	return forces
+}
+
+func checkCorrected(t *testing.T, calibration AccelerometerCalibration, gravity [3]float64) {
+	reading := distortAcceleration(gravity)
+	x, y, z := calibration.Apply(reading[0], reading[1], reading[2])
+	corrected := toG(x, y, z)
+	for i := range corrected {
+		if math.Abs(corrected[i]-gravity[i]) > 0.005 {
+			t.Fatalf("Expected %v but was %v", gravity, corrected)
+		}
+	}
+}
+
+func TestAccelerometerSixPositionCalibration(t *testing.T) {
+	calibrator := NewAccelerometerCalibrator()
+	_, _, err := calibrator.SixPositionCalibration()
+	if err == nil {
+		t.Fatal("Calibration without positions should fail")
+	}
+
+	// The reading needs to be in the right position
+	reading := distortAcceleration([3]float64{0, 0, 1})
+	err = calibrator.AddPosition(ORIENTATION_X_UP, reading[0], reading[1], reading[2])
+	if err == nil {
+		t.Fatal("Reading in the wrong position should fail")
+	}
+
+	for {
+		position, ok := calibrator.NextPosition()
+		if !ok {
+			break
+		}
+		reading := distortAcceleration(expectedGravity(position))
+		err = calibrator.AddPosition(position, reading[0], reading[1], reading[2])
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	calibration, quality, err := calibrator.SixPositionCalibration()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if quality.Samples != 6 || quality.MaxError > physic.EarthGravity/1000 {
+		t.Fatalf("Bad quality %+v", quality)
+	}
+	// Check an orientation that wasn't used for the calibration too
+	checkCorrected(t, calibration, [3]float64{0.6, -0.48, 0.64})
+	checkCorrected(t, calibration, [3]float64{0, 0, -1})
+}
+
+func TestAccelerometerEllipsoidCalibration(t *testing.T) {
+	calibrator := NewAccelerometerCalibrator()
+	for _, sample := range sphereSamples([3]float64{}, 10000) {
+		reading := distortAcceleration([3]float64{float64(sample[0]) / 10000, float64(sample[1]) / 10000, float64(sample[2]) / 10000})
+		calibrator.Add(reading[0], reading[1], reading[2])
+	}
+	calibration, quality, err := calibrator.EllipsoidCalibration()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if quality.RMSError > physic.EarthGravity/1000 {
+		t.Fatalf("Bad quality %+v", quality)
+	}
+	// The ellipsoid fit can't tell whether the distortion includes a
+	// rotation, so only check the offset and the magnitude
+	for i := range accelerometerOffset {
+		offset := float64(calibration.Offset[i]) / float64(physic.EarthGravity)
+		if math.Abs(offset-accelerometerOffset[i]) > 0.001 {
+			t.Fatalf("Expected offset %v but was %v", accelerometerOffset, calibration.Offset)
+		}
+	}
+	reading := distortAcceleration([3]float64{0.6, -0.48, 0.64})
+	corrected := toG(calibration.Apply(reading[0], reading[1], reading[2]))
+	magnitude := math.Sqrt(corrected[0]*corrected[0] + corrected[1]*corrected[1] + corrected[2]*corrected[2])
+	if math.Abs(magnitude-1) > 0.005 {
+		t.Fatalf("Expected 1 G but was %v", magnitude)
+	}
+}
+
+func TestAccelerometerSenseCalibrated(t *testing.T) {
+	scenario := &i2ctest.Playback{
+		Ops: []i2ctest.IO{
+			// X = 1000, Z = 16000
+			{
+				Addr: ACCELEROMETER_ADDRESS,
+				W:    []byte{ACCELEROMETER_OUT_X_L_A | 0x80},
+				R:    []byte{0xE8, 0x03, 0, 0, 0x80, 0x3E},
+			},
+		},
+	}
+
+	accelerometer := &Accelerometer{
+		mmr: mmr.Dev8{
+			Conn:  &i2c.Dev{Bus: scenario, Addr: uint16(ACCELEROMETER_ADDRESS)},
+			Order: binary.BigEndian,
+		},
+		range_: ACCELEROMETER_RANGE_2G,
+		mode:   ACCELEROMETER_MODE_NORMAL,
+		axes:   ACCELEROMETER_AXIS_X | ACCELEROMETER_AXIS_Z,
+	}
+	offset := physic.EarthGravity / 20
+	accelerometer.SetCalibration(&AccelerometerCalibration{
+		Offset: [3]physic.Force{offset, offset, offset},
+		Scale:  [3][3]float64{{2, 0, 0}, {0, 1, 0}, {0, 0, 1}},
+	})
+
+	x, y, z, err := accelerometer.Sense()
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw := AccelerometerSample{X: 1000, Z: 16000, Range: ACCELEROMETER_RANGE_2G, Mode: ACCELEROMETER_MODE_NORMAL}
+	rawX, _, rawZ := raw.Acceleration()
+	// The disabled Y axis stays 0
+	if x != 2*(rawX-offset) || y != 0 || z != rawZ-offset {
+		t.Fatalf("Bad calibrated values %v %v %v", x, y, z)
+	}
+}
@@ -0,0 +1,198 @@
+// Package ahrs estimates orientation by fusing the LSM303's accelerometer and
+// magnetometer with an optional external gyroscope. The LSM303 doesn't have a
+// gyroscope, but it's often paired with one such as the L3GD20.
+//
+// The orientation is a quaternion rotating from the body's forward, right,
+// down axes to north, east, down. Use lsm303.ConvertFrame to get ENU.
+package ahrs
+
+import (
+	"context"
+	"errors"
+	lsm303 "github.com/bskari/go-lsm303"
+	"math"
+	"periph.io/x/periph/conn/physic"
+	"time"
+)
+
+// Implement this for an external gyroscope
+type Gyroscope interface {
+	// Returns the rotation rate per second about the sensor's X, Y and Z axes.
+	// The axes must be aligned with the LSM303's axes.
+	SenseRates() (physic.Angle, physic.Angle, physic.Angle, error)
+}
+
+// *lsm303.Accelerometer implements this
+type Accelerometer interface {
+	Sense() (physic.Force, physic.Force, physic.Force, error)
+}
+
+// *lsm303.Magnetometer implements this
+type Magnetometer interface {
+	Sense() (lsm303.MagneticFluxDensity, lsm303.MagneticFluxDensity, lsm303.MagneticFluxDensity, error)
+}
+
+// One set of readings in the body's forward, right, down axes
+type Sample struct {
+	// Only the directions of the acceleration and field are used, so the
+	// units don't matter
+	Acceleration [3]float64
+	Field        [3]float64
+	// Radians per second. Only used if HasRates is set.
+	Rates    [3]float64
+	HasRates bool
+}
+
+// A sensor fusion filter
+type Filter interface {
+	// Updates the estimate with readings taken the duration after the last
+	// ones. The first update initializes the estimate from the accelerometer
+	// and magnetometer alone.
+	Update(sample Sample, duration time.Duration)
+	Quaternion() lsm303.Quaternion
+}
+
+type Opts struct {
+	// How the sensor is mounted
+	Axes lsm303.CompassAxes
+	// Optional
+	Gyroscope Gyroscope
+	// How often Run updates the filter
+	Rate physic.Frequency
+}
+
+var DefaultOpts = Opts{
+	Axes: lsm303.DefaultCompassAxes,
+	Rate: 50 * physic.Hertz,
+}
+
+// Reads the sensors and updates a filter
+type AHRS struct {
+	accelerometer Accelerometer
+	magnetometer  Magnetometer
+	gyroscope     Gyroscope
+	axes          lsm303.CompassAxes
+	filter        Filter
+	period        time.Duration
+}
+
+func New(accelerometer Accelerometer, magnetometer Magnetometer, filter Filter, opts *Opts) (*AHRS, error) {
+	err := opts.Axes.Check()
+	if err != nil {
+		return nil, err
+	}
+	if opts.Rate <= 0 {
+		return nil, errors.New("AHRS rate must be positive")
+	}
+	return &AHRS{
+		accelerometer: accelerometer,
+		magnetometer:  magnetometer,
+		gyroscope:     opts.Gyroscope,
+		axes:          opts.Axes,
+		filter:        filter,
+		period:        opts.Rate.Period(),
+	}, nil
+}
+
+// Reads the sensors once and updates the filter, assuming that one period
+// has passed since the last update
+func (ahrs *AHRS) Update() (lsm303.Quaternion, error) {
+	sample, err := ahrs.read()
+	if err != nil {
+		return lsm303.Quaternion{}, err
+	}
+	ahrs.filter.Update(sample, ahrs.period)
+	return ahrs.filter.Quaternion(), nil
+}
+
+func (ahrs *AHRS) read() (Sample, error) {
+	xa, ya, za, err := ahrs.accelerometer.Sense()
+	if err != nil {
+		return Sample{}, err
+	}
+	xm, ym, zm, err := ahrs.magnetometer.Sense()
+	if err != nil {
+		return Sample{}, err
+	}
+	sample := Sample{
+		Acceleration: ahrs.axes.ToBody(float64(xa), float64(ya), float64(za)),
+		Field:        ahrs.axes.ToBody(float64(xm), float64(ym), float64(zm)),
+	}
+	if ahrs.gyroscope != nil {
+		x, y, z, err := ahrs.gyroscope.SenseRates()
+		if err != nil {
+			return Sample{}, err
+		}
+		radian := float64(physic.Radian)
+		sample.Rates = ahrs.axes.ToBody(float64(x)/radian, float64(y)/radian, float64(z)/radian)
+		sample.HasRates = true
+	}
+	return sample, nil
+}
+
+// Updates the filter at the rate from Opts and sends the orientation on the
+// returned channel. The channel is closed when the context is done or a read
+// fails.
+func (ahrs *AHRS) Run(ctx context.Context) <-chan lsm303.Quaternion {
+	quaternions := make(chan lsm303.Quaternion)
+	go func() {
+		defer close(quaternions)
+		ticker := time.NewTicker(ahrs.period)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			quaternion, err := ahrs.Update()
+			if err != nil {
+				return
+			}
+			select {
+			case quaternions <- quaternion:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return quaternions
+}
+
+// Computes the orientation from the accelerometer and magnetometer alone, for
+// initializing the filters. Returns false if the readings are degenerate.
+func triad(sample Sample) (lsm303.Quaternion, bool) {
+	matrix, err := lsm303.TriadBody(sample.Acceleration, sample.Field)
+	if err != nil {
+		return lsm303.Quaternion{}, false
+	}
+	return matrix.Quaternion(), true
+}
+
+// Rotates a vector from world axes to body axes
+func toBody(quaternion lsm303.Quaternion, vector [3]float64) [3]float64 {
+	return quaternion.Conjugate().Rotate(vector)
+}
+
+// The field rotated to world axes and flattened onto the north, down plane,
+// which is what the magnetometer should read if the heading were right
+func magneticReference(quaternion lsm303.Quaternion, field [3]float64) [3]float64 {
+	world := quaternion.Rotate(field)
+	return [3]float64{math.Hypot(world[0], world[1]), 0, world[2]}
+}
+
+// The rate of change of the orientation when rotating at the rates
+func derivative(quaternion lsm303.Quaternion, rates [3]float64) lsm303.Quaternion {
+	product := quaternion.Multiply(lsm303.Quaternion{X: rates[0], Y: rates[1], Z: rates[2]})
+	return lsm303.Quaternion{W: product.W / 2, X: product.X / 2, Y: product.Y / 2, Z: product.Z / 2}
+}
+
+func integrate(quaternion, rate lsm303.Quaternion, duration time.Duration) lsm303.Quaternion {
+	seconds := duration.Seconds()
+	return lsm303.Quaternion{
+		W: quaternion.W + rate.W*seconds,
+		X: quaternion.X + rate.X*seconds,
+		Y: quaternion.Y + rate.Y*seconds,
+		Z: quaternion.Z + rate.Z*seconds,
+	}.Normalize()
+}
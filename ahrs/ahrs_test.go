@@ -0,0 +1,168 @@
+package ahrs
+
+import (
+	"context"
+	"errors"
+	lsm303 "github.com/bskari/go-lsm303"
+	"math"
+	"periph.io/x/periph/conn/physic"
+	"testing"
+	"time"
+)
+
+func degrees(roll, pitch, yaw float64) lsm303.EulerAngles {
+	return lsm303.EulerAngles{
+		Roll:  physic.Angle(roll * float64(physic.Degree)),
+		Pitch: physic.Angle(pitch * float64(physic.Degree)),
+		Yaw:   physic.Angle(yaw * float64(physic.Degree)),
+	}
+}
+
+// Simulates body readings for the orientation, with a field inclined about
+// 60 degrees down
+func simulate(angles lsm303.EulerAngles) Sample {
+	transpose := angles.Matrix().Transpose()
+	return Sample{
+		Acceleration: transpose.Rotate([3]float64{0, 0, -9.8}),
+		Field:        transpose.Rotate([3]float64{20000, 0, 34640}),
+	}
+}
+
+// The angle between two orientations, in degrees
+func angleBetween(a, b lsm303.Quaternion) float64 {
+	difference := a.Conjugate().Multiply(b).Normalize()
+	return 2 * math.Acos(math.Min(1, math.Abs(difference.W))) * 180 / math.Pi
+}
+
+type fakeAccelerometer struct {
+	x, y, z physic.Force
+}
+
+func (accelerometer *fakeAccelerometer) Sense() (physic.Force, physic.Force, physic.Force, error) {
+	return accelerometer.x, accelerometer.y, accelerometer.z, nil
+}
+
+type fakeMagnetometer struct {
+	x, y, z lsm303.MagneticFluxDensity
+	err     error
+}
+
+func (magnetometer *fakeMagnetometer) Sense() (lsm303.MagneticFluxDensity, lsm303.MagneticFluxDensity, lsm303.MagneticFluxDensity, error) {
+	return magnetometer.x, magnetometer.y, magnetometer.z, magnetometer.err
+}
+
+type fakeGyroscope struct {
+	x, y, z physic.Angle
+}
+
+func (gyroscope *fakeGyroscope) SenseRates() (physic.Angle, physic.Angle, physic.Angle, error) {
+	return gyroscope.x, gyroscope.y, gyroscope.z, nil
+}
+
+// Records the samples it's given
+type recordingFilter struct {
+	samples   []Sample
+	durations []time.Duration
+}
+
+func (filter *recordingFilter) Update(sample Sample, duration time.Duration) {
+	filter.samples = append(filter.samples, sample)
+	filter.durations = append(filter.durations, duration)
+}
+
+func (filter *recordingFilter) Quaternion() lsm303.Quaternion {
+	return lsm303.Quaternion{W: 1}
+}
+
+func TestUpdate(t *testing.T) {
+	// Face up with X forward, so the sensor's Y is left and Z is up
+	accelerometer := &fakeAccelerometer{0, 0, physic.EarthGravity}
+	magnetometer := &fakeMagnetometer{x: 20 * lsm303.Microtesla, z: -30 * lsm303.Microtesla}
+	gyroscope := &fakeGyroscope{z: 10 * physic.Degree}
+	filter := &recordingFilter{}
+	opts := DefaultOpts
+	opts.Gyroscope = gyroscope
+	ahrs, err := New(accelerometer, magnetometer, filter, &opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = ahrs.Update()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sample := filter.samples[0]
+	if sample.Acceleration != [3]float64{0, 0, -float64(physic.EarthGravity)} {
+		t.Fatalf("Bad acceleration %v", sample.Acceleration)
+	}
+	if sample.Field != [3]float64{float64(20 * lsm303.Microtesla), 0, float64(30 * lsm303.Microtesla)} {
+		t.Fatalf("Bad field %v", sample.Field)
+	}
+	// Counterclockwise about up is counterclockwise about down
+	if !sample.HasRates || math.Abs(sample.Rates[2]+10*math.Pi/180) > 1e-6 {
+		t.Fatalf("Bad rates %v", sample.Rates)
+	}
+	if filter.durations[0] != 20*time.Millisecond {
+		t.Fatalf("Expected 20ms but was %v", filter.durations[0])
+	}
+
+	magnetometer.err = errors.New("Saturated")
+	_, err = ahrs.Update()
+	if err == nil {
+		t.Fatal("Read errors should be returned")
+	}
+}
+
+func TestNew(t *testing.T) {
+	opts := DefaultOpts
+	opts.Axes = lsm303.CompassAxes{Forward: lsm303.SENSOR_AXIS_X, Right: lsm303.SENSOR_AXIS_Y, Down: lsm303.SENSOR_AXIS_NEGATIVE_Z}
+	_, err := New(&fakeAccelerometer{}, &fakeMagnetometer{}, NewMadgwick(), &opts)
+	if err == nil {
+		t.Fatal("Left-handed axes should fail")
+	}
+	opts = DefaultOpts
+	opts.Rate = 0
+	_, err = New(&fakeAccelerometer{}, &fakeMagnetometer{}, NewMadgwick(), &opts)
+	if err == nil {
+		t.Fatal("Zero rate should fail")
+	}
+}
+
+func TestRun(t *testing.T) {
+	opts := DefaultOpts
+	opts.Rate = 1 * physic.KiloHertz
+	accelerometer := &fakeAccelerometer{0, 0, physic.EarthGravity}
+	magnetometer := &fakeMagnetometer{x: 20 * lsm303.Microtesla, z: -30 * lsm303.Microtesla}
+	ahrs, err := New(accelerometer, magnetometer, NewMahony(), &opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	quaternions := ahrs.Run(ctx)
+	for i := 0; i < 3; i++ {
+		quaternion := <-quaternions
+		// Level and facing north
+		if angleBetween(quaternion, lsm303.Quaternion{W: 1}) > 0.01 {
+			t.Fatalf("Expected level and north but was %v", quaternion.EulerAngles())
+		}
+	}
+	cancel()
+	for range quaternions {
+	}
+}
+
+func TestTriad(t *testing.T) {
+	angles := degrees(20, -10, 120)
+	quaternion, ok := triad(simulate(angles))
+	if !ok {
+		t.Fatal("Triad failed")
+	}
+	if angleBetween(quaternion, angles.Quaternion()) > 0.01 {
+		t.Fatalf("Expected %v but was %v", angles, quaternion.EulerAngles())
+	}
+	_, ok = triad(Sample{Field: [3]float64{1, 0, 0}})
+	if ok {
+		t.Fatal("Triad without acceleration should fail")
+	}
+}
@@ -0,0 +1,92 @@
+package ahrs
+
+import (
+	lsm303 "github.com/bskari/go-lsm303"
+	"math"
+	"time"
+)
+
+// Suggested in Madgwick's paper. This corrects about 6 degrees per second.
+const DEFAULT_MADGWICK_BETA = 0.1
+
+// Without a gyroscope, the correction is the only thing that moves the
+// estimate, so it needs to be much faster
+const DEFAULT_MADGWICK_BETA_WITHOUT_GYRO = 2.5
+
+// Madgwick's gradient descent filter. Each update integrates the gyroscope
+// rates and takes a step of Beta radians per second toward the orientation
+// that best matches the accelerometer and magnetometer. Without a gyroscope,
+// the step is proportional to the error instead, so it works as a
+// complementary filter.
+type Madgwick struct {
+	// Used when the sample has rates
+	Beta float64
+	// Used when it doesn't
+	BetaWithoutGyro float64
+
+	quaternion  lsm303.Quaternion
+	initialized bool
+}
+
+func NewMadgwick() *Madgwick {
+	return &Madgwick{Beta: DEFAULT_MADGWICK_BETA, BetaWithoutGyro: DEFAULT_MADGWICK_BETA_WITHOUT_GYRO}
+}
+
+func (filter *Madgwick) Quaternion() lsm303.Quaternion {
+	if !filter.initialized {
+		return lsm303.Quaternion{W: 1}
+	}
+	return filter.quaternion
+}
+
+func (filter *Madgwick) Update(sample Sample, duration time.Duration) {
+	if !filter.initialized {
+		filter.quaternion, filter.initialized = triad(sample)
+		return
+	}
+	q := filter.quaternion
+
+	var rate lsm303.Quaternion
+	beta := filter.BetaWithoutGyro
+	if sample.HasRates {
+		rate = derivative(q, sample.Rates)
+		beta = filter.Beta
+	}
+
+	up, upOk := lsm303.Normalize(sample.Acceleration)
+	field, fieldOk := lsm303.Normalize(sample.Field)
+	if upOk {
+		var gradient lsm303.Quaternion
+		gradient = add(gradient, objectiveGradient(q, [3]float64{0, 0, -1}, up))
+		if fieldOk {
+			gradient = add(gradient, objectiveGradient(q, magneticReference(q, field), field))
+		}
+		// Without rates, the step isn't normalized, so it shrinks as the
+		// error does instead of chattering around the answer
+		length := 1.0
+		if sample.HasRates {
+			length = math.Sqrt(gradient.W*gradient.W + gradient.X*gradient.X + gradient.Y*gradient.Y + gradient.Z*gradient.Z)
+		}
+		if length > 0 {
+			rate.W -= beta * gradient.W / length
+			rate.X -= beta * gradient.X / length
+			rate.Y -= beta * gradient.Y / length
+			rate.Z -= beta * gradient.Z / length
+		}
+	}
+
+	filter.quaternion = integrate(q, rate, duration)
+}
+
+// The gradient of |q* reference q - measured|^2 / 2 with respect to q, which
+// works out to -2 reference q (q* reference q - measured)
+func objectiveGradient(quaternion lsm303.Quaternion, reference, measured [3]float64) lsm303.Quaternion {
+	predicted := toBody(quaternion, reference)
+	difference := lsm303.Quaternion{X: predicted[0] - measured[0], Y: predicted[1] - measured[1], Z: predicted[2] - measured[2]}
+	gradient := lsm303.Quaternion{X: reference[0], Y: reference[1], Z: reference[2]}.Multiply(quaternion).Multiply(difference)
+	return lsm303.Quaternion{W: -2 * gradient.W, X: -2 * gradient.X, Y: -2 * gradient.Y, Z: -2 * gradient.Z}
+}
+
+func add(a, b lsm303.Quaternion) lsm303.Quaternion {
+	return lsm303.Quaternion{W: a.W + b.W, X: a.X + b.X, Y: a.Y + b.Y, Z: a.Z + b.Z}
+}
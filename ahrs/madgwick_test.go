@@ -0,0 +1,72 @@
+package ahrs
+
+import (
+	lsm303 "github.com/bskari/go-lsm303"
+	"math"
+	"periph.io/x/periph/conn/physic"
+	"testing"
+	"time"
+)
+
+const testPeriod = 20 * time.Millisecond
+
+// Runs a filter on readings from a device rotating at a constant rate about
+// its down axis, starting at the angles, and returns the final true
+// orientation
+func rotate(filter Filter, start lsm303.EulerAngles, degreesPerSecond float64, seconds float64, hasRates bool) lsm303.Quaternion {
+	steps := int(seconds / testPeriod.Seconds())
+	angles := start
+	for i := 0; i < steps; i++ {
+		angles.Yaw = start.Yaw + degrees(0, 0, degreesPerSecond*float64(i+1)*testPeriod.Seconds()).Yaw
+		sample := simulate(angles)
+		if hasRates {
+			// Only yaw is changing, which is about the world's down axis
+			yawRate := degreesPerSecond * math.Pi / 180
+			sinRoll, cosRoll := math.Sincos(float64(angles.Roll) / float64(physic.Radian))
+			sinPitch, cosPitch := math.Sincos(float64(angles.Pitch) / float64(physic.Radian))
+			sample.Rates = [3]float64{-sinPitch * yawRate, sinRoll * cosPitch * yawRate, cosRoll * cosPitch * yawRate}
+			sample.HasRates = true
+		}
+		filter.Update(sample, testPeriod)
+	}
+	return angles.Quaternion()
+}
+
+func TestMadgwickInitializes(t *testing.T) {
+	filter := NewMadgwick()
+	angles := degrees(20, -10, 120)
+	filter.Update(simulate(angles), testPeriod)
+	if angleBetween(filter.Quaternion(), angles.Quaternion()) > 0.01 {
+		t.Fatalf("Expected %v but was %v", angles, filter.Quaternion().EulerAngles())
+	}
+}
+
+func TestMadgwickWithoutGyro(t *testing.T) {
+	// Start far from the answer
+	filter := NewMadgwick()
+	filter.quaternion = lsm303.Quaternion{W: 1}
+	filter.initialized = true
+	expected := rotate(filter, degrees(20, -10, 120), 0, 10, false)
+	if angleBetween(filter.Quaternion(), expected) > 0.5 {
+		t.Fatalf("Expected %v but was %v", expected.EulerAngles(), filter.Quaternion().EulerAngles())
+	}
+}
+
+func TestMadgwickWithGyro(t *testing.T) {
+	filter := NewMadgwick()
+	expected := rotate(filter, degrees(10, 5, 0), 30, 6, true)
+	// The correction is computed before the rates are integrated, so the
+	// estimate can be off by up to one sample's rotation
+	if angleBetween(filter.Quaternion(), expected) > 1 {
+		t.Fatalf("Expected %v but was %v", expected.EulerAngles(), filter.Quaternion().EulerAngles())
+	}
+}
+
+// Without rates, the filter lags behind a rotation but still follows it
+func TestMadgwickTracksWithoutGyro(t *testing.T) {
+	filter := NewMadgwick()
+	expected := rotate(filter, degrees(0, 0, 0), 5, 8, false)
+	if angleBetween(filter.Quaternion(), expected) > 5 {
+		t.Fatalf("Expected %v but was %v", expected.EulerAngles(), filter.Quaternion().EulerAngles())
+	}
+}
@@ -0,0 +1,92 @@
+package ahrs
+
+import (
+	lsm303 "github.com/bskari/go-lsm303"
+	"math"
+	"time"
+)
+
+const (
+	DEFAULT_MAHONY_KP = 1.0
+	DEFAULT_MAHONY_KI = 0.05
+	// Much faster for the same reason as DEFAULT_MADGWICK_BETA_WITHOUT_GYRO
+	DEFAULT_MAHONY_KP_WITHOUT_GYRO = 5.0
+)
+
+// Mahony's complementary filter. The error between the measured and predicted
+// directions of gravity and the magnetic field is fed back into the gyroscope
+// rates with a PI controller. The integral term estimates the gyroscope bias.
+type Mahony struct {
+	// Proportional and integral gains, used when the sample has rates
+	Kp float64
+	Ki float64
+	// Proportional gain used when it doesn't. There's no bias to estimate
+	// without a gyroscope, so there's no integral term.
+	KpWithoutGyro float64
+
+	quaternion  lsm303.Quaternion
+	initialized bool
+	// The integral of the error, in radians per second
+	bias [3]float64
+}
+
+func NewMahony() *Mahony {
+	return &Mahony{Kp: DEFAULT_MAHONY_KP, Ki: DEFAULT_MAHONY_KI, KpWithoutGyro: DEFAULT_MAHONY_KP_WITHOUT_GYRO}
+}
+
+func (filter *Mahony) Quaternion() lsm303.Quaternion {
+	if !filter.initialized {
+		return lsm303.Quaternion{W: 1}
+	}
+	return filter.quaternion
+}
+
+// The estimated gyroscope bias, in radians per second, which is added to the
+// rates
+func (filter *Mahony) Bias() [3]float64 {
+	return filter.bias
+}
+
+func (filter *Mahony) Update(sample Sample, duration time.Duration) {
+	if !filter.initialized {
+		filter.quaternion, filter.initialized = triad(sample)
+		return
+	}
+	q := filter.quaternion
+
+	// The error is the rotation from the predicted directions to the measured
+	// ones
+	var feedback [3]float64
+	up, upOk := lsm303.Normalize(sample.Acceleration)
+	if upOk {
+		predictedUp := toBody(q, [3]float64{0, 0, -1})
+		feedback = lsm303.Cross(up, predictedUp)
+		// Only correct the heading with the magnetometer, so that magnetic
+		// disturbances don't affect the tilt. This also keeps the correction
+		// from getting weaker as the field gets steeper.
+		world := q.Rotate(sample.Field)
+		horizontal := math.Hypot(world[0], world[1])
+		if horizontal > 0 {
+			headingError := toBody(q, [3]float64{0, 0, -world[1] / horizontal})
+			for i := range feedback {
+				feedback[i] += headingError[i]
+			}
+		}
+	}
+
+	var rates [3]float64
+	kp := filter.KpWithoutGyro
+	if sample.HasRates {
+		kp = filter.Kp
+		seconds := duration.Seconds()
+		for i := range rates {
+			filter.bias[i] += filter.Ki * feedback[i] * seconds
+			rates[i] = sample.Rates[i] + filter.bias[i]
+		}
+	}
+	for i := range rates {
+		rates[i] += kp * feedback[i]
+	}
+
+	filter.quaternion = integrate(q, derivative(q, rates), duration)
+}
@@ -0,0 +1,63 @@
+package ahrs
+
+import (
+	lsm303 "github.com/bskari/go-lsm303"
+	"math"
+	"testing"
+)
+
+func TestMahonyInitializes(t *testing.T) {
+	filter := NewMahony()
+	angles := degrees(-30, 15, 250)
+	filter.Update(simulate(angles), testPeriod)
+	if angleBetween(filter.Quaternion(), angles.Quaternion()) > 0.01 {
+		t.Fatalf("Expected %v but was %v", angles, filter.Quaternion().EulerAngles())
+	}
+}
+
+func TestMahonyWithoutGyro(t *testing.T) {
+	filter := NewMahony()
+	filter.quaternion = lsm303.Quaternion{W: 1}
+	filter.initialized = true
+	expected := rotate(filter, degrees(20, -10, 120), 0, 10, false)
+	if angleBetween(filter.Quaternion(), expected) > 0.5 {
+		t.Fatalf("Expected %v but was %v", expected.EulerAngles(), filter.Quaternion().EulerAngles())
+	}
+}
+
+func TestMahonyWithGyro(t *testing.T) {
+	filter := NewMahony()
+	expected := rotate(filter, degrees(10, 5, 0), 30, 6, true)
+	// The correction is computed before the rates are integrated, so the
+	// estimate can be off by up to one sample's rotation
+	if angleBetween(filter.Quaternion(), expected) > 1 {
+		t.Fatalf("Expected %v but was %v", expected.EulerAngles(), filter.Quaternion().EulerAngles())
+	}
+}
+
+func TestMahonyTracksWithoutGyro(t *testing.T) {
+	filter := NewMahony()
+	expected := rotate(filter, degrees(0, 0, 0), 5, 8, false)
+	if angleBetween(filter.Quaternion(), expected) > 5 {
+		t.Fatalf("Expected %v but was %v", expected.EulerAngles(), filter.Quaternion().EulerAngles())
+	}
+}
+
+// A biased gyroscope is corrected by the integral term
+func TestMahonyBias(t *testing.T) {
+	filter := NewMahony()
+	angles := degrees(5, 5, 45)
+	for i := 0; i < 5000; i++ {
+		sample := simulate(angles)
+		sample.Rates = [3]float64{0, 0, 0.02}
+		sample.HasRates = true
+		filter.Update(sample, testPeriod)
+	}
+	if angleBetween(filter.Quaternion(), angles.Quaternion()) > 0.5 {
+		t.Fatalf("Expected %v but was %v", angles, filter.Quaternion().EulerAngles())
+	}
+	bias := filter.Bias()
+	if math.Abs(bias[2]+0.02) > 0.002 {
+		t.Fatalf("Expected a bias of -0.02 but was %v", bias)
+	}
+}
@@ -0,0 +1,185 @@
+package lsm303
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+// The magnetometer outputs this when an axis is out of range for the gain
+const MAGNETOMETER_SATURATED = -4096
+
+// ErrSaturated can be used with errors.Is to check for a *SaturationError.
+var ErrSaturated = errors.New("Magnetometer saturated")
+
+// Returned with the readings when at least one axis is saturated
+type SaturationError struct {
+	X, Y, Z bool
+}
+
+func (err *SaturationError) Error() string {
+	var axes []string
+	for i, saturated := range [...]bool{err.X, err.Y, err.Z} {
+		if saturated {
+			axes = append(axes, [...]string{"X", "Y", "Z"}[i])
+		}
+	}
+	return "Magnetometer saturated on " + strings.Join(axes, ", ")
+}
+
+func (err *SaturationError) Is(target error) bool {
+	return target == ErrSaturated
+}
+
+// Returns nil instead of a nil *SaturationError so that the result can be
+// returned as an error
+func checkSaturation(xValue, yValue, zValue int16) error {
+	saturation := SaturationError{
+		X: xValue == MAGNETOMETER_SATURATED,
+		Y: yValue == MAGNETOMETER_SATURATED,
+		Z: zValue == MAGNETOMETER_SATURATED,
+	}
+	if !saturation.X && !saturation.Y && !saturation.Z {
+		return nil
+	}
+	return &saturation
+}
+
+// Enables or disables automatic gain ranging in Sense. When the field gets
+// close to the limit of the current gain, or saturates, the gain is stepped
+// up. When the field would fit comfortably in the next lower gain, the gain is
+// stepped down.
+func (magnetometer *Magnetometer) SetAutoGain(enabled bool) {
+	magnetometer.autoGain = enabled
+}
+
+const (
+	// Step up when a reading is above this fraction of the gain's range
+	magnetometerGainUpFraction = 0.9
+	// Step down when a reading is below this fraction of the lower gain's
+	// range. The gap between this and magnetometerGainUpFraction is the
+	// hysteresis that keeps the gain from bouncing between two settings.
+	magnetometerGainDownFraction = 0.7
+)
+
+// Gets the range of the gain in gauss
+func getMagnetometerGainRange(gain MagnetometerGain) float64 {
+	return [...]float64{1.3, 1.9, 2.5, 4.0, 4.7, 5.6, 8.1}[gain]
+}
+
+func nextMagnetometerGain(gain MagnetometerGain, saturated bool, x, y, z MagneticFluxDensity) MagnetometerGain {
+	if saturated {
+		if gain < MAGNETOMETER_GAIN_8_1 {
+			return gain + 1
+		}
+		return gain
+	}
+
+	largest := 0.0
+	for _, value := range [...]MagneticFluxDensity{x, y, z} {
+		if value < 0 {
+			value = -value
+		}
+		if value.Gauss() > largest {
+			largest = value.Gauss()
+		}
+	}
+
+	if gain < MAGNETOMETER_GAIN_8_1 && largest > magnetometerGainUpFraction*getMagnetometerGainRange(gain) {
+		return gain + 1
+	}
+	if gain > MAGNETOMETER_GAIN_1_3 && largest < magnetometerGainDownFraction*getMagnetometerGainRange(gain-1) {
+		return gain - 1
+	}
+	return gain
+}
+
+// Policy for automatically switching the accelerometer range. The range is
+// stepped up as soon as a sample gets close to full scale, and stepped down
+// once samples have fit in the lower range for the dwell time.
+type AccelerometerAutoRange struct {
+	Enabled bool
+	// Step up when any axis is above this fraction of full scale, e.g. 0.9
+	UpFraction float64
+	// Step down when every axis is below this fraction of the lower range's
+	// full scale, e.g. 0.5. This must be less than UpFraction, and the gap
+	// between them is the hysteresis.
+	DownFraction float64
+	// How long samples need to fit in the lower range before stepping down
+	Dwell time.Duration
+}
+
+// DefaultAccelerometerAutoRange is a reasonable auto range policy.
+var DefaultAccelerometerAutoRange = AccelerometerAutoRange{
+	Enabled:      true,
+	UpFraction:   0.9,
+	DownFraction: 0.5,
+	Dwell:        time.Second,
+}
+
+// Sets the auto range policy used by Sense, SenseSample and WaitForSample.
+// SenseRaw never changes the range.
+func (accelerometer *Accelerometer) SetAutoRange(policy AccelerometerAutoRange) error {
+	if policy.Enabled && (policy.DownFraction <= 0 || policy.DownFraction >= policy.UpFraction || policy.UpFraction > 1) {
+		return errors.New("Auto range needs 0 < DownFraction < UpFraction <= 1")
+	}
+	accelerometer.autoRange = policy
+	accelerometer.belowSince = time.Time{}
+	return nil
+}
+
+// Raw samples are left justified, so full scale is the same in every mode
+const accelerometerFullScale = 32768
+
+func (accelerometer *Accelerometer) updateAutoRange(sample AccelerometerSample, now time.Time) error {
+	policy := accelerometer.autoRange
+	if !policy.Enabled {
+		return nil
+	}
+
+	largest := 0
+	for _, value := range [...]int16{sample.X, sample.Y, sample.Z} {
+		magnitude := int(value)
+		if magnitude < 0 {
+			magnitude = -magnitude
+		}
+		if magnitude > largest {
+			largest = magnitude
+		}
+	}
+
+	next := sample.Range
+	if largest > int(policy.UpFraction*accelerometerFullScale) {
+		accelerometer.belowSince = time.Time{}
+		if sample.Range < ACCELEROMETER_RANGE_16G {
+			next = sample.Range + 1
+		}
+	} else if sample.Range > ACCELEROMETER_RANGE_2G && largest < int(policy.DownFraction*accelerometerFullScale*lowerRangeFraction(sample.Mode, sample.Range)) {
+		if accelerometer.belowSince.IsZero() {
+			accelerometer.belowSince = now
+		}
+		if now.Sub(accelerometer.belowSince) >= policy.Dwell {
+			next = sample.Range - 1
+		}
+	} else {
+		accelerometer.belowSince = time.Time{}
+	}
+
+	if next == sample.Range {
+		return nil
+	}
+	accelerometer.belowSince = time.Time{}
+	err := accelerometer.SetRange(next)
+	if err != nil {
+		return err
+	}
+	// The output registers still hold a sample from the old range, and at low
+	// data rates there might not be a new one yet
+	return accelerometer.waitForNewSample()
+}
+
+// The fraction of the range's raw span that the next lower range covers. This
+// is usually 1/2, but the 16G range has 3 times the sensitivity of 8G.
+func lowerRangeFraction(mode AccelerometerMode, range_ AccelerometerRange) float64 {
+	return float64(getMultiplier(mode, range_-1)) / float64(getMultiplier(mode, range_))
+}
@@ -0,0 +1,370 @@
+package lsm303
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"periph.io/x/periph/conn/i2c"
+	"periph.io/x/periph/conn/i2c/i2ctest"
+	"periph.io/x/periph/conn/mmr"
+	"periph.io/x/periph/conn/physic"
+	"testing"
+	"time"
+)
+
+func TestMagnetometerSaturation(t *testing.T) {
+	scenario := &i2ctest.Playback{
+		Ops: []i2ctest.IO{
+			// X and Y saturated
+			{Addr: MAGNETOMETER_ADDRESS, W: []byte{MAGNETOMETER_OUT_X_H_M}, R: []byte{0xF0, 0, 0, 100, 0xF0, 0}},
+		},
+	}
+
+	magnetometer := &Magnetometer{
+		mmr: mmr.Dev8{
+			Conn:  &i2c.Dev{Bus: scenario, Addr: uint16(MAGNETOMETER_ADDRESS)},
+			Order: binary.BigEndian,
+		},
+		gain: MAGNETOMETER_GAIN_1_3,
+	}
+
+	x, y, z, err := magnetometer.SenseRaw()
+	if !errors.Is(err, ErrSaturated) {
+		t.Fatalf("Expected ErrSaturated but was %v", err)
+	}
+	saturation, ok := err.(*SaturationError)
+	if !ok || *saturation != (SaturationError{X: true, Y: true}) {
+		t.Fatalf("Bad saturation %v", err)
+	}
+	if x != MAGNETOMETER_SATURATED || y != MAGNETOMETER_SATURATED || z != 100 {
+		t.Fatalf("Bad values %v %v %v", x, y, z)
+	}
+}
+
+func TestMagnetometerAutoGain(t *testing.T) {
+	// After a gain change, the stale sample is thrown away and Sense waits
+	// for a new one
+	settle := []i2ctest.IO{
+		{Addr: MAGNETOMETER_ADDRESS, W: []byte{MAGNETOMETER_MR_REG_M}, R: []byte{0}},
+		{Addr: MAGNETOMETER_ADDRESS, W: []byte{MAGNETOMETER_OUT_X_H_M}, R: []byte{0, 0, 0, 0, 0, 0}},
+		{Addr: MAGNETOMETER_ADDRESS, W: []byte{MAGNETOMETER_SR_REG_M}, R: []byte{0}},
+		{Addr: MAGNETOMETER_ADDRESS, W: []byte{MAGNETOMETER_SR_REG_M}, R: []byte{0x01}},
+	}
+	var ops []i2ctest.IO
+	// Saturated, so step up from 1.3 to 1.9
+	ops = append(ops,
+		i2ctest.IO{Addr: MAGNETOMETER_ADDRESS, W: []byte{MAGNETOMETER_OUT_X_H_M}, R: []byte{0xF0, 0, 0, 0, 0, 0}},
+		i2ctest.IO{Addr: MAGNETOMETER_ADDRESS, W: []byte{MAGNETOMETER_CRB_REG_M}, R: []byte{0x20}},
+		i2ctest.IO{Addr: MAGNETOMETER_ADDRESS, W: []byte{MAGNETOMETER_CRB_REG_M, 0x40}, R: []byte{}},
+	)
+	ops = append(ops, settle...)
+	// 1.8 gauss at 855 LSB/gauss is close to the limit, so step up to 2.5
+	ops = append(ops,
+		i2ctest.IO{Addr: MAGNETOMETER_ADDRESS, W: []byte{MAGNETOMETER_OUT_X_H_M}, R: []byte{0x06, 0x03, 0, 0, 0, 0}},
+		i2ctest.IO{Addr: MAGNETOMETER_ADDRESS, W: []byte{MAGNETOMETER_CRB_REG_M}, R: []byte{0x40}},
+		i2ctest.IO{Addr: MAGNETOMETER_ADDRESS, W: []byte{MAGNETOMETER_CRB_REG_M, 0x60}, R: []byte{}},
+	)
+	ops = append(ops, settle...)
+	// 1.5 gauss would fit in 1.9, but not comfortably, so stay
+	ops = append(ops,
+		i2ctest.IO{Addr: MAGNETOMETER_ADDRESS, W: []byte{MAGNETOMETER_OUT_X_H_M}, R: []byte{0x03, 0xED, 0, 0, 0, 0}},
+	)
+	// 0.5 gauss steps back down
+	ops = append(ops,
+		i2ctest.IO{Addr: MAGNETOMETER_ADDRESS, W: []byte{MAGNETOMETER_OUT_X_H_M}, R: []byte{0x01, 0x4F, 0, 0, 0, 0}},
+		i2ctest.IO{Addr: MAGNETOMETER_ADDRESS, W: []byte{MAGNETOMETER_CRB_REG_M}, R: []byte{0x60}},
+		i2ctest.IO{Addr: MAGNETOMETER_ADDRESS, W: []byte{MAGNETOMETER_CRB_REG_M, 0x40}, R: []byte{}},
+	)
+	ops = append(ops, settle...)
+	scenario := &i2ctest.Playback{Ops: ops}
+
+	magnetometer := &Magnetometer{
+		mmr: mmr.Dev8{
+			Conn:  &i2c.Dev{Bus: scenario, Addr: uint16(MAGNETOMETER_ADDRESS)},
+			Order: binary.BigEndian,
+		},
+		gain: MAGNETOMETER_GAIN_1_3,
+		rate: MAGNETOMETER_RATE_15,
+	}
+	magnetometer.SetAutoGain(true)
+
+	_, _, _, err := magnetometer.Sense()
+	if !errors.Is(err, ErrSaturated) {
+		t.Fatalf("Expected ErrSaturated but was %v", err)
+	}
+	expected := [...]MagnetometerGain{MAGNETOMETER_GAIN_2_5, MAGNETOMETER_GAIN_2_5, MAGNETOMETER_GAIN_1_9}
+	for _, gain := range expected {
+		_, _, _, err = magnetometer.Sense()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if magnetometer.gain != gain {
+			t.Fatalf("Expected gain %v but was %v", gain, magnetometer.gain)
+		}
+	}
+	err = scenario.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMagnetometerAutoGainSingleMode(t *testing.T) {
+	scenario := &i2ctest.Playback{
+		Ops: []i2ctest.IO{
+			{Addr: MAGNETOMETER_ADDRESS, W: []byte{MAGNETOMETER_OUT_X_H_M}, R: []byte{0xF0, 0, 0, 0, 0, 0}},
+			{Addr: MAGNETOMETER_ADDRESS, W: []byte{MAGNETOMETER_CRB_REG_M}, R: []byte{0x20}},
+			{Addr: MAGNETOMETER_ADDRESS, W: []byte{MAGNETOMETER_CRB_REG_M, 0x40}, R: []byte{}},
+			// Asleep after SenseOnce, so there's no new sample to wait for
+			{Addr: MAGNETOMETER_ADDRESS, W: []byte{MAGNETOMETER_MR_REG_M}, R: []byte{0x03}},
+		},
+	}
+
+	magnetometer := &Magnetometer{
+		mmr: mmr.Dev8{
+			Conn:  &i2c.Dev{Bus: scenario, Addr: uint16(MAGNETOMETER_ADDRESS)},
+			Order: binary.BigEndian,
+		},
+		gain: MAGNETOMETER_GAIN_1_3,
+		rate: MAGNETOMETER_RATE_15,
+	}
+	magnetometer.SetAutoGain(true)
+
+	_, _, _, err := magnetometer.Sense()
+	if !errors.Is(err, ErrSaturated) {
+		t.Fatalf("Expected ErrSaturated but was %v", err)
+	}
+	if magnetometer.gain != MAGNETOMETER_GAIN_1_9 {
+		t.Fatalf("Expected gain 1.9 but was %v", magnetometer.gain)
+	}
+	err = scenario.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAccelerometerAutoRange(t *testing.T) {
+	discard := i2ctest.IO{
+		Addr: ACCELEROMETER_ADDRESS,
+		W:    []byte{ACCELEROMETER_OUT_X_L_A | 0x80},
+		R:    []byte{0, 0, 0, 0, 0, 0},
+	}
+	newSample := i2ctest.IO{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_STATUS_REG_A}, R: []byte{0x08}}
+	scenario := &i2ctest.Playback{
+		Ops: []i2ctest.IO{
+			// 31000 is close to full scale
+			{
+				Addr: ACCELEROMETER_ADDRESS,
+				W:    []byte{ACCELEROMETER_OUT_X_L_A | 0x80},
+				R:    []byte{0x18, 0x79, 0, 0, 0, 0},
+			},
+			// Step up to 4G
+			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_CTRL_REG4_A}, R: []byte{0x80}},
+			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_CTRL_REG4_A, 0x90}, R: []byte{}},
+			discard,
+			newSample,
+			// Step back down to 2G
+			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_CTRL_REG4_A}, R: []byte{0x90}},
+			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_CTRL_REG4_A, 0x80}, R: []byte{}},
+			discard,
+			newSample,
+		},
+	}
+
+	accelerometer := &Accelerometer{
+		mmr: mmr.Dev8{
+			Conn:  &i2c.Dev{Bus: scenario, Addr: uint16(ACCELEROMETER_ADDRESS)},
+			Order: binary.BigEndian,
+		},
+		range_: ACCELEROMETER_RANGE_2G,
+		mode:   ACCELEROMETER_MODE_NORMAL,
+		axes:   ACCELEROMETER_AXES_ALL,
+	}
+	err := accelerometer.SetAutoRange(DefaultAccelerometerAutoRange)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sample, err := accelerometer.SenseSample()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The sample is tagged with the range it was taken at, not the new one
+	if sample.X != 31000 || sample.Range != ACCELEROMETER_RANGE_2G {
+		t.Fatalf("Bad sample %+v", sample)
+	}
+	if accelerometer.range_ != ACCELEROMETER_RANGE_4G {
+		t.Fatalf("Expected 4G but was %v", accelerometer.range_)
+	}
+
+	start := time.Now()
+	steps := []struct {
+		x        int16
+		after    time.Duration
+		expected AccelerometerRange
+	}{
+		// Fits in 2G, start the dwell timer
+		{5000, 0, ACCELEROMETER_RANGE_4G},
+		// Too big for 2G, restart the dwell timer
+		{10000, 500 * time.Millisecond, ACCELEROMETER_RANGE_4G},
+		{5000, 600 * time.Millisecond, ACCELEROMETER_RANGE_4G},
+		{5000, 1500 * time.Millisecond, ACCELEROMETER_RANGE_4G},
+		// Dwell time reached
+		{5000, 1600 * time.Millisecond, ACCELEROMETER_RANGE_2G},
+	}
+	for _, step := range steps {
+		sample := AccelerometerSample{X: step.x, Range: accelerometer.range_}
+		err = accelerometer.updateAutoRange(sample, start.Add(step.after))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if accelerometer.range_ != step.expected {
+			t.Fatalf("After %v expected %v but was %v", step.after, step.expected, accelerometer.range_)
+		}
+	}
+}
+
+func TestAccelerometerAutoRangeLowDataRate(t *testing.T) {
+	// 31000 at 2G
+	stale := []byte{0x18, 0x79, 0, 0, 0, 0}
+	scenario := &i2ctest.Playback{
+		Ops: []i2ctest.IO{
+			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_OUT_X_L_A | 0x80}, R: stale},
+			// Step up to 4G
+			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_CTRL_REG4_A}, R: []byte{0x80}},
+			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_CTRL_REG4_A, 0x90}, R: []byte{}},
+			// At 10 Hz, there's no new conversion yet, so the output registers
+			// still hold the 2G sample
+			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_OUT_X_L_A | 0x80}, R: stale},
+			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_STATUS_REG_A}, R: []byte{0}},
+			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_STATUS_REG_A}, R: []byte{0x08}},
+			// The same acceleration at 4G
+			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_OUT_X_L_A | 0x80}, R: []byte{0x8C, 0x3C, 0, 0, 0, 0}},
+		},
+	}
+
+	accelerometer := &Accelerometer{
+		mmr: mmr.Dev8{
+			Conn:  &i2c.Dev{Bus: scenario, Addr: uint16(ACCELEROMETER_ADDRESS)},
+			Order: binary.BigEndian,
+		},
+		range_: ACCELEROMETER_RANGE_2G,
+		mode:   ACCELEROMETER_MODE_NORMAL,
+		rate:   ACCELEROMETER_RATE_10,
+		axes:   ACCELEROMETER_AXES_ALL,
+	}
+	err := accelerometer.SetAutoRange(DefaultAccelerometerAutoRange)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := accelerometer.SenseSample()
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := accelerometer.SenseSample()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.X != 15500 || second.Range != ACCELEROMETER_RANGE_4G {
+		t.Fatalf("Bad sample %+v", second)
+	}
+	x1, _, _ := first.Acceleration()
+	x2, _, _ := second.Acceleration()
+	if math.Abs(float64(x1-x2)) > 0.01*float64(x1) {
+		t.Fatalf("Expected the same acceleration but was %v and %v", x1, x2)
+	}
+	err = scenario.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAccelerometerAutoRangeValidation(t *testing.T) {
+	accelerometer := &Accelerometer{}
+	err := accelerometer.SetAutoRange(AccelerometerAutoRange{Enabled: true, UpFraction: 0.5, DownFraction: 0.6})
+	if err == nil {
+		t.Fatal("DownFraction above UpFraction should be rejected")
+	}
+}
+
+func TestNewAccelerometerAutoRangeValidation(t *testing.T) {
+	scenario := &i2ctest.Playback{
+		Ops: []i2ctest.IO{
+			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_CTRL_REG1_A, 0x57}, R: []byte{}},
+			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_IDENTIFY}, R: []byte{0x33}},
+			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_CTRL_REG4_A}, R: []byte{0}},
+			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_CTRL_REG4_A, 0x80}, R: []byte{}},
+			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_CTRL_REG4_A}, R: []byte{0x80}},
+			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_CTRL_REG4_A}, R: []byte{0x80}},
+			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_CTRL_REG4_A, 0x90}, R: []byte{}},
+			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_CTRL_REG1_A}, R: []byte{0x57}},
+			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_CTRL_REG1_A, 0x57}, R: []byte{}},
+			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_CTRL_REG4_A}, R: []byte{0x90}},
+			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_CTRL_REG4_A, 0x90}, R: []byte{}},
+		},
+	}
+	opts := DefaultAccelerometerOpts
+	// Without the fractions, every nonzero sample would step the range up
+	opts.AutoRange = AccelerometerAutoRange{Enabled: true}
+	_, err := NewAccelerometer(scenario, &opts)
+	if err == nil {
+		t.Fatal("Auto range without fractions should be rejected")
+	}
+}
+
+func TestAccelerometerAutoRangeRescalesThresholds(t *testing.T) {
+	scenario := &i2ctest.Playback{
+		Ops: []i2ctest.IO{
+			// Step up to 4G
+			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_CTRL_REG4_A}, R: []byte{0x80}},
+			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_CTRL_REG4_A, 0x90}, R: []byte{}},
+			// 0.5 G is 16 LSB at 4G for both, and interrupt 2 is disabled
+			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_INT1_THS_A, 16}, R: []byte{}},
+			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_CLICK_THS_A, 16}, R: []byte{}},
+			// Discard the old sample and wait for a new one
+			{
+				Addr: ACCELEROMETER_ADDRESS,
+				W:    []byte{ACCELEROMETER_OUT_X_L_A | 0x80},
+				R:    []byte{0, 0, 0, 0, 0, 0},
+			},
+			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_STATUS_REG_A}, R: []byte{0x08}},
+		},
+	}
+
+	accelerometer := &Accelerometer{
+		mmr: mmr.Dev8{
+			Conn:  &i2c.Dev{Bus: scenario, Addr: uint16(ACCELEROMETER_ADDRESS)},
+			Order: binary.BigEndian,
+		},
+		range_:              ACCELEROMETER_RANGE_2G,
+		mode:                ACCELEROMETER_MODE_NORMAL,
+		axes:                ACCELEROMETER_AXES_ALL,
+		interruptThresholds: [2]physic.Force{physic.EarthGravity / 2, 0},
+		clickEnabled:        true,
+		click:               ClickConfig{Threshold: physic.EarthGravity / 2},
+	}
+	err := accelerometer.SetAutoRange(DefaultAccelerometerAutoRange)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sample := AccelerometerSample{X: 31000, Range: accelerometer.range_}
+	err = accelerometer.updateAutoRange(sample, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if accelerometer.range_ != ACCELEROMETER_RANGE_4G {
+		t.Fatalf("Expected 4G but was %v", accelerometer.range_)
+	}
+}
+
+func TestLowerRangeFraction(t *testing.T) {
+	fraction := lowerRangeFraction(ACCELEROMETER_MODE_NORMAL, ACCELEROMETER_RANGE_4G)
+	if math.Abs(fraction-0.5) > 0.01 {
+		t.Errorf("4G to 2G should be 1/2 but was %v", fraction)
+	}
+	fraction = lowerRangeFraction(ACCELEROMETER_MODE_NORMAL, ACCELEROMETER_RANGE_16G)
+	if math.Abs(fraction-1.0/3) > 0.01 {
+		t.Errorf("16G to 8G should be 1/3 but was %v", fraction)
+	}
+}
@@ -0,0 +1,197 @@
+package lsm303
+
+import (
+	"errors"
+	"math"
+)
+
+// Corrects magnetometer readings for distortion from nearby metal
+type MagnetometerCalibration struct {
+	// Hard-iron offset, subtracted from every reading
+	HardIron [3]MagneticFluxDensity `json:"hard_iron"`
+	// Soft-iron correction, multiplied with every reading after the hard-iron
+	// offset is removed. The zero matrix is treated as the identity, so only
+	// the offset is applied.
+	SoftIron [3][3]float64 `json:"soft_iron"`
+}
+
+// Applies the correction to a reading
+func (calibration *MagnetometerCalibration) Apply(x, y, z MagneticFluxDensity) (MagneticFluxDensity, MagneticFluxDensity, MagneticFluxDensity) {
+	x -= calibration.HardIron[0]
+	y -= calibration.HardIron[1]
+	z -= calibration.HardIron[2]
+	if calibration.SoftIron == ([3][3]float64{}) {
+		return x, y, z
+	}
+	corrected := multiply3(calibration.SoftIron, [3]float64{float64(x), float64(y), float64(z)})
+	return MagneticFluxDensity(math.Round(corrected[0])), MagneticFluxDensity(math.Round(corrected[1])), MagneticFluxDensity(math.Round(corrected[2]))
+}
+
+// How well an ellipsoid fit matched the samples
+type EllipsoidFit struct {
+	// The radius of the sphere that the corrected readings lie on, in the
+	// units of the readings. For the magnetometer, this is the local field
+	// strength in nanotesla.
+	Radius float64
+	// The RMS distance of the corrected samples from the sphere, as a
+	// fraction of the radius. A good fit is under 0.02.
+	Residual float64
+	// The ratio of the shortest to the longest axis of the uncorrected
+	// ellipsoid, where 1 means no soft-iron distortion
+	AxisRatio float64
+	// How much of the sphere the samples cover, from 0 to 1. The fit can be
+	// poorly constrained if this is low, even if the residual is small.
+	Coverage float64
+}
+
+// Sets the calibration that Sense applies to every reading. Pass nil to
+// disable it.
+func (magnetometer *Magnetometer) SetCalibration(calibration *MagnetometerCalibration) {
+	magnetometer.calibration = calibration
+}
+
+// Collects raw magnetometer samples while the device is rotated through as
+// many orientations as possible, and computes a calibration from them.
+type MagnetometerCalibrator struct {
+	gain    MagnetometerGain
+	samples [][3]int16
+	minimum [3]int16
+	maximum [3]int16
+}
+
+// The samples must all be taken at this gain
+func NewMagnetometerCalibrator(gain MagnetometerGain) *MagnetometerCalibrator {
+	return &MagnetometerCalibrator{gain: gain}
+}
+
+// Adds a sample from SenseRaw. Saturated samples are ignored.
+func (calibrator *MagnetometerCalibrator) Add(x, y, z int16) {
+	if checkSaturation(x, y, z) != nil {
+		return
+	}
+	sample := [3]int16{x, y, z}
+	for i, value := range sample {
+		if len(calibrator.samples) == 0 || value < calibrator.minimum[i] {
+			calibrator.minimum[i] = value
+		}
+		if len(calibrator.samples) == 0 || value > calibrator.maximum[i] {
+			calibrator.maximum[i] = value
+		}
+	}
+	calibrator.samples = append(calibrator.samples, sample)
+}
+
+func (calibrator *MagnetometerCalibrator) Len() int {
+	return len(calibrator.samples)
+}
+
+// Returns the minimum and maximum raw values seen on each axis
+func (calibrator *MagnetometerCalibrator) Extrema() ([3]int16, [3]int16) {
+	return calibrator.minimum, calibrator.maximum
+}
+
+// The hard-iron offset is the center of the extrema on each axis
+func (calibrator *MagnetometerCalibrator) HardIron() (MagneticFluxDensity, MagneticFluxDensity, MagneticFluxDensity) {
+	var center [3]int16
+	for i := range center {
+		center[i] = int16((int32(calibrator.minimum[i]) + int32(calibrator.maximum[i])) / 2)
+	}
+	return convertMagnetometer(center[0], center[1], center[2], calibrator.gain)
+}
+
+const (
+	// The sphere is divided into this many bands of equal area...
+	coverageBands = 6
+	// ...and each band into this many sectors
+	coverageSectors = 12
+)
+
+// Estimates how much of the sphere the samples cover, from 0 to 1. Rotate
+// the device until this is close to 1 for a good calibration.
+func (calibrator *MagnetometerCalibrator) Coverage() float64 {
+	if len(calibrator.samples) == 0 {
+		return 0
+	}
+
+	// Normalize each axis by its half range, so that the directions are
+	// measured on a sphere even if the field is squashed into an ellipsoid
+	var center, halfRange [3]float64
+	for i := range center {
+		center[i] = (float64(calibrator.minimum[i]) + float64(calibrator.maximum[i])) / 2
+		halfRange[i] = (float64(calibrator.maximum[i]) - float64(calibrator.minimum[i])) / 2
+		if halfRange[i] == 0 {
+			return 0
+		}
+	}
+
+	var covered [coverageBands][coverageSectors]bool
+	count := 0
+	for _, sample := range calibrator.samples {
+		var direction [3]float64
+		for i := range direction {
+			direction[i] = (float64(sample[i]) - center[i]) / halfRange[i]
+		}
+		length := math.Sqrt(direction[0]*direction[0] + direction[1]*direction[1] + direction[2]*direction[2])
+		if length == 0 {
+			continue
+		}
+		// Bands that are uniform in z have equal area
+		band := int((direction[2]/length + 1) / 2 * coverageBands)
+		if band >= coverageBands {
+			band = coverageBands - 1
+		}
+		sector := int((math.Atan2(direction[1], direction[0]) + math.Pi) / (2 * math.Pi) * coverageSectors)
+		if sector >= coverageSectors {
+			sector = coverageSectors - 1
+		}
+		if !covered[band][sector] {
+			covered[band][sector] = true
+			count++
+		}
+	}
+	return float64(count) / (coverageBands * coverageSectors)
+}
+
+// Computes a hard-iron calibration
+func (calibrator *MagnetometerCalibrator) Calibration() (MagnetometerCalibration, error) {
+	if len(calibrator.samples) < 2 {
+		return MagnetometerCalibration{}, errors.New("Not enough magnetometer samples to calibrate")
+	}
+	for i := range calibrator.minimum {
+		if calibrator.minimum[i] == calibrator.maximum[i] {
+			return MagnetometerCalibration{}, errors.New("Magnetometer samples don't cover every axis")
+		}
+	}
+	x, y, z := calibrator.HardIron()
+	return MagnetometerCalibration{HardIron: [3]MagneticFluxDensity{x, y, z}}, nil
+}
+
+// Computes a hard and soft-iron calibration with a least squares ellipsoid fit.
+// The correction maps the readings onto a sphere with the same volume as the
+// ellipsoid, so the field strength is roughly preserved.
+func (calibrator *MagnetometerCalibrator) EllipsoidCalibration() (MagnetometerCalibration, EllipsoidFit, error) {
+	points := make([][3]float64, len(calibrator.samples))
+	for i, sample := range calibrator.samples {
+		// The Z axis has a different sensitivity, so convert before fitting
+		x, y, z := convertMagnetometer(sample[0], sample[1], sample[2], calibrator.gain)
+		points[i] = [3]float64{float64(x), float64(y), float64(z)}
+	}
+	center, shape, err := fitEllipsoid(points)
+	if err != nil {
+		return MagnetometerCalibration{}, EllipsoidFit{}, err
+	}
+	radius := ellipsoidRadius(shape)
+	correction := ellipsoidCorrection(shape, radius)
+
+	calibration := MagnetometerCalibration{SoftIron: correction}
+	for i := range center {
+		calibration.HardIron[i] = MagneticFluxDensity(math.Round(center[i]))
+	}
+	fit := EllipsoidFit{
+		Radius:    radius,
+		Residual:  sphereResidual(points, center, correction, radius),
+		AxisRatio: ellipsoidAxisRatio(shape),
+		Coverage:  calibrator.Coverage(),
+	}
+	return calibration, fit, nil
+}
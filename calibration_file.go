@@ -0,0 +1,232 @@
+package lsm303
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"periph.io/x/periph/conn/physic"
+	"time"
+)
+
+// The version of the calibration file format written by Save. Bump this when
+// making incompatible changes.
+const CALIBRATION_FILE_VERSION = 1
+
+// The only sensor this package supports
+const SENSOR_VARIANT = "LSM303DLHC"
+
+// Everything needed to correct a device's readings, in a form that can be
+// saved to a file. Offsets are stored in the package's units, so
+// accelerometer offsets are in nanonewtons per kilogram (physic.Force),
+// magnetometer offsets in nanotesla and the temperature offset in nanokelvin.
+type Calibration struct {
+	Version int `json:"version"`
+	// The sensor the calibration was made with
+	Variant string `json:"variant"`
+	// When the calibration was made
+	Created time.Time `json:"created"`
+	// The settings the calibration was made with. The corrections might not
+	// be accurate with other settings.
+	AccelerometerRange AccelerometerRange `json:"accelerometer_range"`
+	AccelerometerMode  AccelerometerMode  `json:"accelerometer_mode"`
+	MagnetometerGain   MagnetometerGain   `json:"magnetometer_gain"`
+
+	Accelerometer *AccelerometerCalibration `json:"accelerometer,omitempty"`
+	Magnetometer  *MagnetometerCalibration  `json:"magnetometer,omitempty"`
+	// Added to the magnetometer's relative temperature to get the actual
+	// temperature
+	TemperatureOffset physic.Temperature `json:"temperature_offset"`
+	// Rotates readings from the sensor's axes to the axes of whatever it's
+	// mounted on. This is applied after the other corrections. The zero
+	// matrix is treated as the identity.
+	Mounting [3][3]float64 `json:"mounting"`
+}
+
+// Creates a calibration that records the current settings of the devices.
+// Either device can be nil.
+func NewCalibration(accelerometer *Accelerometer, magnetometer *Magnetometer) *Calibration {
+	calibration := &Calibration{
+		Version: CALIBRATION_FILE_VERSION,
+		Variant: SENSOR_VARIANT,
+		Created: time.Now().UTC(),
+	}
+	if accelerometer != nil {
+		calibration.AccelerometerRange = accelerometer.range_
+		calibration.AccelerometerMode = accelerometer.mode
+	}
+	if magnetometer != nil {
+		calibration.MagnetometerGain = magnetometer.gain
+	}
+	return calibration
+}
+
+// A setting that differs between the calibration and the device it's applied
+// to
+type CalibrationMismatch struct {
+	Setting    string
+	Calibrated string
+	Device     string
+}
+
+func (mismatch CalibrationMismatch) String() string {
+	return fmt.Sprintf("%s was %s when calibrated but is %s", mismatch.Setting, mismatch.Calibrated, mismatch.Device)
+}
+
+// Sets the corrections on the devices, either of which can be nil. The
+// calibration is applied even if the devices are configured differently from
+// when it was made, but the differences are returned as warnings.
+func (calibration *Calibration) Apply(accelerometer *Accelerometer, magnetometer *Magnetometer) []CalibrationMismatch {
+	var mismatches []CalibrationMismatch
+	if calibration.Variant != SENSOR_VARIANT {
+		mismatches = append(mismatches, CalibrationMismatch{"Variant", calibration.Variant, SENSOR_VARIANT})
+	}
+
+	if accelerometer != nil {
+		if accelerometer.range_ != calibration.AccelerometerRange {
+			mismatches = append(mismatches, CalibrationMismatch{"Accelerometer range", calibration.AccelerometerRange.String(), accelerometer.range_.String()})
+		}
+		if accelerometer.mode != calibration.AccelerometerMode {
+			mismatches = append(mismatches, CalibrationMismatch{"Accelerometer mode", calibration.AccelerometerMode.String(), accelerometer.mode.String()})
+		}
+		if calibration.Accelerometer != nil || calibration.Mounting != ([3][3]float64{}) {
+			corrected := AccelerometerCalibration{}
+			if calibration.Accelerometer != nil {
+				corrected = *calibration.Accelerometer
+			}
+			corrected.Scale = calibration.mount(corrected.Scale)
+			accelerometer.SetCalibration(&corrected)
+		} else {
+			accelerometer.SetCalibration(nil)
+		}
+	}
+
+	if magnetometer != nil {
+		if magnetometer.gain != calibration.MagnetometerGain {
+			mismatches = append(mismatches, CalibrationMismatch{"Magnetometer gain", calibration.MagnetometerGain.String(), magnetometer.gain.String()})
+		}
+		if calibration.Magnetometer != nil || calibration.Mounting != ([3][3]float64{}) {
+			corrected := MagnetometerCalibration{}
+			if calibration.Magnetometer != nil {
+				corrected = *calibration.Magnetometer
+			}
+			corrected.SoftIron = calibration.mount(corrected.SoftIron)
+			magnetometer.SetCalibration(&corrected)
+		} else {
+			magnetometer.SetCalibration(nil)
+		}
+		magnetometer.SetTemperatureOffset(calibration.TemperatureOffset)
+	}
+	return mismatches
+}
+
+// Combines the mounting rotation with a correction matrix, where the zero
+// matrix means the identity for both
+func (calibration *Calibration) mount(correction [3][3]float64) [3][3]float64 {
+	if calibration.Mounting == ([3][3]float64{}) {
+		return correction
+	}
+	if correction == ([3][3]float64{}) {
+		return calibration.Mounting
+	}
+	var result [3][3]float64
+	for i := range result {
+		for j := range result[i] {
+			for k := range correction {
+				result[i][j] += calibration.Mounting[i][k] * correction[k][j]
+			}
+		}
+	}
+	return result
+}
+
+// Writes the calibration as JSON
+func (calibration *Calibration) Write(writer io.Writer) error {
+	encoder := json.NewEncoder(writer)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(calibration)
+}
+
+func (calibration *Calibration) Save(path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	err = calibration.Write(file)
+	if err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// Reads a calibration written by Write
+func ReadCalibration(reader io.Reader) (*Calibration, error) {
+	calibration := &Calibration{}
+	err := json.NewDecoder(reader).Decode(calibration)
+	if err != nil {
+		return nil, err
+	}
+	if calibration.Version == 0 {
+		return nil, errors.New("Calibration file has no version")
+	}
+	if calibration.Version > CALIBRATION_FILE_VERSION {
+		return nil, fmt.Errorf("Calibration file version %d is newer than the supported version %d", calibration.Version, CALIBRATION_FILE_VERSION)
+	}
+	return calibration, nil
+}
+
+func LoadCalibration(path string) (*Calibration, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ReadCalibration(file)
+}
+
+// The settings are stored by name so that the file doesn't depend on the
+// order of the constants
+
+func (range_ AccelerometerRange) MarshalText() ([]byte, error) {
+	return []byte(range_.String()), nil
+}
+
+func (range_ *AccelerometerRange) UnmarshalText(text []byte) error {
+	for value := ACCELEROMETER_RANGE_2G; value <= ACCELEROMETER_RANGE_16G; value++ {
+		if value.String() == string(text) {
+			*range_ = value
+			return nil
+		}
+	}
+	return errors.New("Unknown accelerometer range " + string(text))
+}
+
+func (mode AccelerometerMode) MarshalText() ([]byte, error) {
+	return []byte(mode.String()), nil
+}
+
+func (mode *AccelerometerMode) UnmarshalText(text []byte) error {
+	for value := ACCELEROMETER_MODE_NORMAL; value <= ACCELEROMETER_MODE_LOW_POWER; value++ {
+		if value.String() == string(text) {
+			*mode = value
+			return nil
+		}
+	}
+	return errors.New("Unknown accelerometer mode " + string(text))
+}
+
+func (gain MagnetometerGain) MarshalText() ([]byte, error) {
+	return []byte(gain.String()), nil
+}
+
+func (gain *MagnetometerGain) UnmarshalText(text []byte) error {
+	for value := MAGNETOMETER_GAIN_1_3; value <= MAGNETOMETER_GAIN_8_1; value++ {
+		if value.String() == string(text) {
+			*gain = value
+			return nil
+		}
+	}
+	return errors.New("Unknown magnetometer gain " + string(text))
+}
@@ -0,0 +1,132 @@
+package lsm303
+
+import (
+	"bytes"
+	"encoding/binary"
+	"path/filepath"
+	"periph.io/x/periph/conn/i2c"
+	"periph.io/x/periph/conn/i2c/i2ctest"
+	"periph.io/x/periph/conn/mmr"
+	"periph.io/x/periph/conn/physic"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCalibrationSaveLoad(t *testing.T) {
+	accelerometer := &Accelerometer{range_: ACCELEROMETER_RANGE_8G, mode: ACCELEROMETER_MODE_HIGH_RESOLUTION}
+	magnetometer := &Magnetometer{gain: MAGNETOMETER_GAIN_1_9}
+	calibration := NewCalibration(accelerometer, magnetometer)
+	calibration.Accelerometer = &AccelerometerCalibration{
+		Offset: [3]physic.Force{1, -2, 3},
+		Scale:  [3][3]float64{{1.01, 0.002, 0}, {0, 0.99, 0}, {0, 0, 1}},
+	}
+	calibration.Magnetometer = &MagnetometerCalibration{
+		HardIron: [3]MagneticFluxDensity{100, 200, -300},
+		SoftIron: [3][3]float64{{1.1, 0, 0}, {0, 0.9, 0.05}, {0, 0.05, 1}},
+	}
+	calibration.TemperatureOffset = 20 * physic.Kelvin
+	calibration.Mounting = [3][3]float64{{0, 1, 0}, {-1, 0, 0}, {0, 0, 1}}
+
+	path := filepath.Join(t.TempDir(), "calibration.json")
+	err := calibration.Save(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadCalibration(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded, calibration) {
+		t.Fatalf("Expected %+v but was %+v", calibration, loaded)
+	}
+
+	// Settings are stored by name
+	var buffer bytes.Buffer
+	err = calibration.Write(&buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{`"version": 1`, `"variant": "LSM303DLHC"`, `"accelerometer_range": "8G"`, `"magnetometer_gain": "1.9"`} {
+		if !strings.Contains(buffer.String(), expected) {
+			t.Fatalf("Expected %v in %v", expected, buffer.String())
+		}
+	}
+}
+
+func TestReadCalibrationVersion(t *testing.T) {
+	tests := []struct {
+		file string
+		ok   bool
+	}{
+		{`{"version": 1, "accelerometer_range": "2G"}`, true},
+		{`{"accelerometer_range": "2G"}`, false},
+		{`{"version": 2}`, false},
+		{`{"version": 1, "accelerometer_range": "3G"}`, false},
+	}
+	for _, test := range tests {
+		_, err := ReadCalibration(strings.NewReader(test.file))
+		if (err == nil) != test.ok {
+			t.Errorf("Reading %v returned %v", test.file, err)
+		}
+	}
+}
+
+func TestCalibrationApply(t *testing.T) {
+	calibration := &Calibration{
+		Version:            CALIBRATION_FILE_VERSION,
+		Variant:            SENSOR_VARIANT,
+		AccelerometerRange: ACCELEROMETER_RANGE_2G,
+		MagnetometerGain:   MAGNETOMETER_GAIN_1_3,
+		Magnetometer:       &MagnetometerCalibration{HardIron: [3]MagneticFluxDensity{Gauss, 0, 0}},
+		// X forward on the sensor is Y on the body
+		Mounting: [3][3]float64{{0, -1, 0}, {1, 0, 0}, {0, 0, 1}},
+	}
+	accelerometer := &Accelerometer{range_: ACCELEROMETER_RANGE_4G}
+	magnetometer := &Magnetometer{gain: MAGNETOMETER_GAIN_1_3}
+
+	mismatches := calibration.Apply(accelerometer, magnetometer)
+	expected := []CalibrationMismatch{{"Accelerometer range", "2G", "4G"}}
+	if !reflect.DeepEqual(mismatches, expected) {
+		t.Fatalf("Expected %v but was %v", expected, mismatches)
+	}
+	if mismatches[0].String() != "Accelerometer range was 2G when calibrated but is 4G" {
+		t.Fatalf("Bad warning %v", mismatches[0])
+	}
+
+	// The mounting rotation is applied after the hard-iron offset
+	x, y, z := magnetometer.calibration.Apply(2*Gauss, 0, Gauss)
+	if x != 0 || y != Gauss || z != Gauss {
+		t.Fatalf("Bad mounted magnetometer values %v %v %v", x, y, z)
+	}
+	// Even without an accelerometer calibration
+	xa, ya, za := accelerometer.calibration.Apply(physic.EarthGravity, 0, 0)
+	if xa != 0 || ya != physic.EarthGravity || za != 0 {
+		t.Fatalf("Bad mounted accelerometer values %v %v %v", xa, ya, za)
+	}
+}
+
+func TestSenseTemperature(t *testing.T) {
+	scenario := &i2ctest.Playback{
+		Ops: []i2ctest.IO{
+			// 2 degrees
+			{Addr: MAGNETOMETER_ADDRESS, W: []byte{MAGNETOMETER_TEMP_OUT_H_M}, R: []byte{0x01}},
+			{Addr: MAGNETOMETER_ADDRESS, W: []byte{MAGNETOMETER_TEMP_OUT_L_M}, R: []byte{0x00}},
+		},
+	}
+
+	magnetometer := &Magnetometer{
+		mmr: mmr.Dev8{
+			Conn:  &i2c.Dev{Bus: scenario, Addr: uint16(MAGNETOMETER_ADDRESS)},
+			Order: binary.BigEndian,
+		},
+	}
+	magnetometer.SetTemperatureOffset(20 * physic.Kelvin)
+	temperature, err := magnetometer.SenseTemperature()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if temperature != 22*physic.Celsius+physic.ZeroCelsius {
+		t.Fatalf("Expected 22°C but was %v", temperature)
+	}
+}
@@ -0,0 +1,156 @@
+package lsm303
+
+import (
+	"encoding/binary"
+	"math"
+	"periph.io/x/periph/conn/i2c"
+	"periph.io/x/periph/conn/i2c/i2ctest"
+	"periph.io/x/periph/conn/mmr"
+	"testing"
+)
+
+// Rotates a field of the given radius about the center through the whole
+// sphere
+func sphereSamples(center [3]float64, radius float64) [][3]int16 {
+	var samples [][3]int16
+	for elevation := -85.0; elevation <= 85; elevation += 10 {
+		for azimuth := 0.0; azimuth < 360; azimuth += 10 {
+			e := elevation * math.Pi / 180
+			a := azimuth * math.Pi / 180
+			samples = append(samples, [3]int16{
+				int16(math.Round(center[0] + radius*math.Cos(e)*math.Cos(a))),
+				int16(math.Round(center[1] + radius*math.Cos(e)*math.Sin(a))),
+				int16(math.Round(center[2] + radius*math.Sin(e))),
+			})
+		}
+	}
+	return samples
+}
+
+func TestMagnetometerCalibrator(t *testing.T) {
+	calibrator := NewMagnetometerCalibrator(MAGNETOMETER_GAIN_1_3)
+	_, err := calibrator.Calibration()
+	if err == nil {
+		t.Fatal("Calibration without samples should fail")
+	}
+	if calibrator.Coverage() != 0 {
+		t.Fatal("Coverage without samples should be 0")
+	}
+
+	// 110 raw is 0.1 gauss on X and Y, 98 raw is 0.1 gauss on Z
+	for _, sample := range sphereSamples([3]float64{110, -220, 98}, 500) {
+		calibrator.Add(sample[0], sample[1], sample[2])
+	}
+	// Saturated samples are ignored
+	calibrator.Add(MAGNETOMETER_SATURATED, 0, 0)
+
+	coverage := calibrator.Coverage()
+	if coverage < 0.99 {
+		t.Fatalf("Expected full coverage but was %v", coverage)
+	}
+	calibration, err := calibrator.Calibration()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := [3]MagneticFluxDensity{Gauss / 10, -Gauss / 5, Gauss / 10}
+	if calibration.HardIron != expected {
+		t.Fatalf("Expected %v but was %v", expected, calibration.HardIron)
+	}
+}
+
+func TestMagnetometerCalibratorCoverage(t *testing.T) {
+	calibrator := NewMagnetometerCalibrator(MAGNETOMETER_GAIN_1_3)
+	// Only rotated flat, so only the equator is covered
+	for azimuth := 0.0; azimuth < 360; azimuth += 10 {
+		a := azimuth * math.Pi / 180
+		calibrator.Add(int16(500*math.Cos(a)), int16(500*math.Sin(a)), 0)
+	}
+	calibrator.Add(0, 0, 500)
+	calibrator.Add(0, 0, -500)
+	coverage := calibrator.Coverage()
+	if coverage == 0 || coverage > 0.5 {
+		t.Fatalf("Expected partial coverage but was %v", coverage)
+	}
+}
+
+func TestMagnetometerSenseCalibrated(t *testing.T) {
+	scenario := &i2ctest.Playback{
+		Ops: []i2ctest.IO{
+			// X = 1100, Z = 980, Y = -1100
+			{Addr: MAGNETOMETER_ADDRESS, W: []byte{MAGNETOMETER_OUT_X_H_M}, R: []byte{0x04, 0x4C, 0x03, 0xD4, 0xFB, 0xB4}},
+			{Addr: MAGNETOMETER_ADDRESS, W: []byte{MAGNETOMETER_OUT_X_H_M}, R: []byte{0x04, 0x4C, 0x03, 0xD4, 0xFB, 0xB4}},
+		},
+	}
+
+	magnetometer := &Magnetometer{
+		mmr: mmr.Dev8{
+			Conn:  &i2c.Dev{Bus: scenario, Addr: uint16(MAGNETOMETER_ADDRESS)},
+			Order: binary.BigEndian,
+		},
+		gain: MAGNETOMETER_GAIN_1_3,
+	}
+	magnetometer.SetCalibration(&MagnetometerCalibration{
+		HardIron: [3]MagneticFluxDensity{Gauss / 2, -Gauss / 2, Gauss},
+	})
+	x, y, z, err := magnetometer.Sense()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if x != Gauss/2 || y != -Gauss/2 || z != 0 {
+		t.Fatalf("Bad calibrated values %v %v %v", x, y, z)
+	}
+
+	magnetometer.SetCalibration(nil)
+	x, y, z, err = magnetometer.Sense()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if x != Gauss || y != -Gauss || z != Gauss {
+		t.Fatalf("Bad uncalibrated values %v %v %v", x, y, z)
+	}
+}
+
+func TestMagnetometerEllipsoidCalibration(t *testing.T) {
+	// A 0.5 gauss field, squashed by soft iron and offset by hard iron
+	distortion := [3][3]float64{{1.1, 0.15, 0}, {0.15, 0.85, -0.05}, {0, -0.05, 0.95}}
+	hardIron := [3]float64{0.08, -0.15, 0.2}
+	calibrator := NewMagnetometerCalibrator(MAGNETOMETER_GAIN_1_3)
+	for _, sample := range sphereSamples([3]float64{}, 5000) {
+		gauss := multiply3(distortion, [3]float64{float64(sample[0]) / 10000, float64(sample[1]) / 10000, float64(sample[2]) / 10000})
+		calibrator.Add(
+			int16(math.Round((gauss[0]+hardIron[0])*1100)),
+			int16(math.Round((gauss[1]+hardIron[1])*1100)),
+			int16(math.Round((gauss[2]+hardIron[2])*980)),
+		)
+	}
+
+	calibration, fit, err := calibrator.EllipsoidCalibration()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range hardIron {
+		offset := float64(calibration.HardIron[i]) / float64(Gauss)
+		if math.Abs(offset-hardIron[i]) > 0.002 {
+			t.Fatalf("Expected hard iron %v but was %v", hardIron, calibration.HardIron)
+		}
+	}
+	if fit.Residual > 0.01 {
+		t.Fatalf("Residual too large %v", fit.Residual)
+	}
+	if fit.AxisRatio > 0.8 {
+		t.Fatalf("The distortion should be reported, but the axis ratio was %v", fit.AxisRatio)
+	}
+	if fit.Coverage < 0.99 {
+		t.Fatalf("Expected full coverage but was %v", fit.Coverage)
+	}
+
+	// The corrected readings lie on a sphere
+	for _, sample := range calibrator.samples {
+		x, y, z := convertMagnetometer(sample[0], sample[1], sample[2], MAGNETOMETER_GAIN_1_3)
+		x, y, z = calibration.Apply(x, y, z)
+		length := math.Sqrt(float64(x)*float64(x) + float64(y)*float64(y) + float64(z)*float64(z))
+		if math.Abs(length-fit.Radius)/fit.Radius > 0.03 {
+			t.Fatalf("Corrected reading %v %v %v is off the sphere of radius %v", x, y, z, fit.Radius)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package lsm303
+
+import (
+	"errors"
+	"math"
+	"periph.io/x/periph/conn/physic"
+	"time"
+)
+
+type ClickConfig struct {
+	// Axes that detect single clicks
+	Single AccelerometerAxes
+	// Axes that detect double clicks
+	Double AccelerometerAxes
+	// The acceleration needed to count as a click. This is scaled by the
+	// current range and rewritten whenever the range changes.
+	Threshold physic.Force
+	// The acceleration must drop below the threshold within this time. This
+	// and the other times are scaled by the current data rate and rewritten
+	// whenever the data rate changes.
+	TimeLimit time.Duration
+	// For double clicks, how long to wait after the first click before
+	// looking for the second
+	Latency time.Duration
+	// For double clicks, how long to look for the second click
+	Window time.Duration
+	// Which pin to route click events to
+	Interrupt AccelerometerInterrupt
+}
+
+type ClickEvent struct {
+	Axis AccelerometerAxes
+	// The click was in the negative direction
+	Negative bool
+	Double   bool
+}
+
+func (event ClickEvent) String() string {
+	result := event.Axis.String()
+	if event.Negative {
+		result = "-" + result
+	} else {
+		result = "+" + result
+	}
+	if event.Double {
+		return result + " double click"
+	}
+	return result + " single click"
+}
+
+// Configures click detection. Setting no axes disables it.
+func (accelerometer *Accelerometer) ConfigureClick(config ClickConfig) error {
+	if config.Interrupt != ACCELEROMETER_INTERRUPT_1 && config.Interrupt != ACCELEROMETER_INTERRUPT_2 {
+		return errors.New("Unknown accelerometer interrupt")
+	}
+	threshold, err := getClickThreshold(config.Threshold, accelerometer.range_)
+	if err != nil {
+		return err
+	}
+	times, err := getClickTimeWrites(config, accelerometer.rate)
+	if err != nil {
+		return err
+	}
+
+	// The configuration register alternates single and double click bits for
+	// X, Y and Z
+	configuration := uint8(0)
+	for i, axis := range [...]AccelerometerAxes{ACCELEROMETER_AXIS_X, ACCELEROMETER_AXIS_Y, ACCELEROMETER_AXIS_Z} {
+		if config.Single.Has(axis) {
+			configuration |= 1 << (2 * i)
+		}
+		if config.Double.Has(axis) {
+			configuration |= 1 << (2*i + 1)
+		}
+	}
+
+	writes := []registerWrite{{ACCELEROMETER_CLICK_THS_A, threshold}}
+	writes = append(writes, times...)
+	writes = append(writes, registerWrite{ACCELEROMETER_CLICK_CFG_A, configuration})
+	for _, write := range writes {
+		err = accelerometer.mmr.WriteUint8(write.register, write.value)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Route to only the requested pin
+	enabled1 := uint8(0)
+	enabled2 := uint8(0)
+	if configuration != 0 {
+		if config.Interrupt == ACCELEROMETER_INTERRUPT_1 {
+			enabled1 = 1
+		} else {
+			enabled2 = 1
+		}
+	}
+	// I1_CLICK
+	err = writeBits(&accelerometer.mmr, ACCELEROMETER_CTRL_REG3_A, 1, 7, enabled1)
+	if err != nil {
+		return err
+	}
+	// I2_CLICKen
+	err = writeBits(&accelerometer.mmr, ACCELEROMETER_CTRL_REG6_A, 1, 7, enabled2)
+	if err != nil {
+		return err
+	}
+	time.Sleep(time.Millisecond * 20)
+
+	accelerometer.clickEnabled = configuration != 0
+	accelerometer.clickInterrupt = config.Interrupt
+	accelerometer.click = config
+
+	return nil
+}
+
+// Reads and decodes the click source register. Returns no events if there
+// hasn't been a click.
+func (accelerometer *Accelerometer) ReadClicks() ([]ClickEvent, error) {
+	value, err := accelerometer.mmr.ReadUint8(ACCELEROMETER_CLICK_SRC_A)
+	if err != nil {
+		return nil, err
+	}
+	return decodeClickSource(value), nil
+}
+
+func decodeClickSource(value uint8) []ClickEvent {
+	active := readBits(uint32(value), 1, 6) == 1
+	if !active {
+		return nil
+	}
+	double := readBits(uint32(value), 1, 5) == 1
+	single := readBits(uint32(value), 1, 4) == 1
+	negative := readBits(uint32(value), 1, 3) == 1
+
+	var events []ClickEvent
+	for _, axis := range [...]AccelerometerAxes{ACCELEROMETER_AXIS_X, ACCELEROMETER_AXIS_Y, ACCELEROMETER_AXIS_Z} {
+		if !AccelerometerAxes(value).Has(axis) {
+			continue
+		}
+		if single {
+			events = append(events, ClickEvent{Axis: axis, Negative: negative})
+		}
+		if double {
+			events = append(events, ClickEvent{Axis: axis, Negative: negative, Double: true})
+		}
+	}
+	return events
+}
+
+// Converts the click times to sample counts for the data rate
+func getClickTimeWrites(config ClickConfig, rate AccelerometerDataRate) ([]registerWrite, error) {
+	timeLimit, err := getSampleCount(config.TimeLimit, rate, 127)
+	if err != nil {
+		return nil, err
+	}
+	latency, err := getSampleCount(config.Latency, rate, 255)
+	if err != nil {
+		return nil, err
+	}
+	window, err := getSampleCount(config.Window, rate, 255)
+	if err != nil {
+		return nil, err
+	}
+	return []registerWrite{
+		{ACCELEROMETER_TIME_LIMIT_A, timeLimit},
+		{ACCELEROMETER_TIME_LATENCY_A, latency},
+		{ACCELEROMETER_TIME_WINDOW_A, window},
+	}, nil
+}
+
+// Converts a threshold to the 7 bit register value for the range. The LSB is
+// the full scale divided by 128.
+func getClickThreshold(threshold physic.Force, range_ AccelerometerRange) (uint8, error) {
+	var fullScale int64
+	switch range_ {
+	case ACCELEROMETER_RANGE_2G:
+		fullScale = 2
+	case ACCELEROMETER_RANGE_4G:
+		fullScale = 4
+	case ACCELEROMETER_RANGE_8G:
+		fullScale = 8
+	case ACCELEROMETER_RANGE_16G:
+		fullScale = 16
+	default:
+		return 0, errors.New("Unknown accelerometer range")
+	}
+	if threshold < 0 {
+		return 0, errors.New("Click threshold must not be negative")
+	}
+	lsb := float64(physic.EarthGravity) * float64(fullScale) / 128
+	value := math.Round(float64(threshold) / lsb)
+	if value > 127 {
+		return 0, errors.New("Click threshold is too large for range " + range_.String())
+	}
+	return uint8(value), nil
+}
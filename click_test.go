@@ -0,0 +1,107 @@
+package lsm303
+
+import (
+	"encoding/binary"
+	"periph.io/x/periph/conn/i2c"
+	"periph.io/x/periph/conn/i2c/i2ctest"
+	"periph.io/x/periph/conn/mmr"
+	"periph.io/x/periph/conn/physic"
+	"testing"
+	"time"
+)
+
+func TestConfigureClick(t *testing.T) {
+	scenario := &i2ctest.Playback{
+		Ops: []i2ctest.IO{
+			// 1 G / (2 G / 128) = 64
+			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_CLICK_THS_A, 64}, R: []byte{}},
+			// 400 Hz
+			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_TIME_LIMIT_A, 4}, R: []byte{}},
+			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_TIME_LATENCY_A, 40}, R: []byte{}},
+			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_TIME_WINDOW_A, 100}, R: []byte{}},
+			// Single Z, double X and Z
+			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_CLICK_CFG_A, 0b110010}, R: []byte{}},
+			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_CTRL_REG3_A}, R: []byte{0}},
+			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_CTRL_REG3_A, 0x80}, R: []byte{}},
+			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_CTRL_REG6_A}, R: []byte{0x80}},
+			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_CTRL_REG6_A, 0}, R: []byte{}},
+			// Negative Z double click
+			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_CLICK_SRC_A}, R: []byte{0b01101100}},
+			// Nothing
+			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_CLICK_SRC_A}, R: []byte{0}},
+			// Slow down to 100 Hz, which rewrites the times
+			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_CTRL_REG1_A}, R: []byte{0x77}},
+			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_CTRL_REG1_A, 0x57}, R: []byte{}},
+			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_TIME_LIMIT_A, 1}, R: []byte{}},
+			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_TIME_LATENCY_A, 10}, R: []byte{}},
+			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_TIME_WINDOW_A, 25}, R: []byte{}},
+		},
+	}
+
+	accelerometer := &Accelerometer{
+		mmr: mmr.Dev8{
+			Conn:  &i2c.Dev{Bus: scenario, Addr: uint16(ACCELEROMETER_ADDRESS)},
+			Order: binary.BigEndian,
+		},
+		range_: ACCELEROMETER_RANGE_2G,
+		mode:   ACCELEROMETER_MODE_NORMAL,
+		rate:   ACCELEROMETER_RATE_400,
+	}
+
+	err := accelerometer.ConfigureClick(ClickConfig{
+		Single:    ACCELEROMETER_AXIS_Z,
+		Double:    ACCELEROMETER_AXIS_X | ACCELEROMETER_AXIS_Z,
+		Threshold: physic.EarthGravity,
+		TimeLimit: 10 * time.Millisecond,
+		Latency:   100 * time.Millisecond,
+		Window:    250 * time.Millisecond,
+		Interrupt: ACCELEROMETER_INTERRUPT_1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events, err := accelerometer.ReadClicks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 {
+		t.Fatalf("Expected 1 event but got %v", events)
+	}
+	expected := ClickEvent{Axis: ACCELEROMETER_AXIS_Z, Negative: true, Double: true}
+	if events[0] != expected {
+		t.Fatalf("Expected %v but was %v", expected, events[0])
+	}
+
+	events, err = accelerometer.ReadClicks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 0 {
+		t.Fatalf("Expected no events but got %v", events)
+	}
+
+	err = accelerometer.SetDataRate(ACCELEROMETER_RATE_100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = scenario.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConfigureClickRejectsLongTimes(t *testing.T) {
+	accelerometer := &Accelerometer{
+		range_: ACCELEROMETER_RANGE_2G,
+		rate:   ACCELEROMETER_RATE_400,
+	}
+	err := accelerometer.ConfigureClick(ClickConfig{
+		Single:    ACCELEROMETER_AXIS_Z,
+		Threshold: physic.EarthGravity,
+		TimeLimit: time.Second,
+	})
+	if err == nil {
+		t.Fatal("A time limit above 127 samples should be rejected")
+	}
+}
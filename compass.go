@@ -0,0 +1,176 @@
+package lsm303
+
+import (
+	"errors"
+	"math"
+	"periph.io/x/periph/conn/physic"
+)
+
+// One of the sensor's axes, in either direction
+type SensorAxis int
+
+const (
+	SENSOR_AXIS_X SensorAxis = iota
+	SENSOR_AXIS_NEGATIVE_X
+	SENSOR_AXIS_Y
+	SENSOR_AXIS_NEGATIVE_Y
+	SENSOR_AXIS_Z
+	SENSOR_AXIS_NEGATIVE_Z
+)
+
+func (axis SensorAxis) String() string {
+	return [...]string{"+X", "-X", "+Y", "-Y", "+Z", "-Z"}[axis]
+}
+
+// Returns the unit vector in sensor coordinates
+func (axis SensorAxis) vector() [3]float64 {
+	var vector [3]float64
+	if axis%2 == 0 {
+		vector[axis/2] = 1
+	} else {
+		vector[axis/2] = -1
+	}
+	return vector
+}
+
+// Which sensor axes point forward, right and down on whatever the sensor is
+// mounted on. They need to form a right-handed coordinate system.
+type CompassAxes struct {
+	Forward SensorAxis
+	Right   SensorAxis
+	Down    SensorAxis
+}
+
+// The sensor mounted flat and face up, with the X arrow on the board pointing
+// forward
+var DefaultCompassAxes = CompassAxes{
+	Forward: SENSOR_AXIS_X,
+	Right:   SENSOR_AXIS_NEGATIVE_Y,
+	Down:    SENSOR_AXIS_NEGATIVE_Z,
+}
+
+// Y forward, X right and Z up, which is how the old README example assumed the
+// sensor was mounted
+var YForwardCompassAxes = CompassAxes{
+	Forward: SENSOR_AXIS_Y,
+	Right:   SENSOR_AXIS_X,
+	Down:    SENSOR_AXIS_NEGATIVE_Z,
+}
+
+// Returns an error if the axes aren't right-handed
+func (axes CompassAxes) Check() error {
+	forward := axes.Forward.vector()
+	right := axes.Right.vector()
+	down := axes.Down.vector()
+	cross := [3]float64{
+		forward[1]*right[2] - forward[2]*right[1],
+		forward[2]*right[0] - forward[0]*right[2],
+		forward[0]*right[1] - forward[1]*right[0],
+	}
+	if cross != down {
+		return errors.New("Compass axes must be right-handed")
+	}
+	return nil
+}
+
+// Converts a sensor reading to forward, right, down
+func (axes CompassAxes) ToBody(x, y, z float64) [3]float64 {
+	sensor := [3]float64{x, y, z}
+	var body [3]float64
+	for i, axis := range [...]SensorAxis{axes.Forward, axes.Right, axes.Down} {
+		vector := axis.vector()
+		body[i] = vector[0]*sensor[0] + vector[1]*sensor[1] + vector[2]*sensor[2]
+	}
+	return body
+}
+
+type Attitude struct {
+	// Positive is nose up, from -90 to 90 degrees
+	Pitch physic.Angle
+	// Positive is right side down, from -180 to 180 degrees
+	Roll physic.Angle
+	// Clockwise from magnetic north when seen from above, from 0 to 360
+	// degrees
+	Heading physic.Angle
+}
+
+// When pitched this close to straight up or down, roll can't be told apart
+// from heading, so it's reported as 0 and all of the rotation is put in the
+// heading
+const gimbalLockLimit = 1e-3
+
+// Computes the attitude from calibrated readings. The accelerometer should
+// only be measuring gravity, so the result is wrong while the device is
+// accelerating.
+func ComputeAttitude(axes CompassAxes, xa, ya, za physic.Force, xm, ym, zm MagneticFluxDensity) (Attitude, error) {
+	err := axes.Check()
+	if err != nil {
+		return Attitude{}, err
+	}
+	acceleration := axes.ToBody(float64(xa), float64(ya), float64(za))
+	field := axes.ToBody(float64(xm), float64(ym), float64(zm))
+
+	magnitude := math.Sqrt(acceleration[0]*acceleration[0] + acceleration[1]*acceleration[1] + acceleration[2]*acceleration[2])
+	if magnitude == 0 {
+		return Attitude{}, errors.New("No acceleration, so tilt is unknown")
+	}
+	// At rest, the accelerometer measures the reaction to gravity, which is
+	// up, so the down axis reads -1 G when level
+	yz := math.Sqrt(acceleration[1]*acceleration[1] + acceleration[2]*acceleration[2])
+	pitch := math.Atan2(acceleration[0], yz)
+	roll := 0.0
+	if yz > gimbalLockLimit*magnitude {
+		roll = math.Atan2(-acceleration[1], -acceleration[2])
+	}
+
+	// Rotate the field back to level
+	sinPitch, cosPitch := math.Sincos(pitch)
+	sinRoll, cosRoll := math.Sincos(roll)
+	north := field[0]*cosPitch + field[1]*sinRoll*sinPitch + field[2]*cosRoll*sinPitch
+	east := field[1]*cosRoll - field[2]*sinRoll
+	if north == 0 && east == 0 {
+		return Attitude{}, errors.New("No horizontal magnetic field, so heading is unknown")
+	}
+	heading := math.Atan2(-east, north)
+	if heading < 0 {
+		heading += 2 * math.Pi
+	}
+
+	return Attitude{
+		Pitch:   toAngle(pitch),
+		Roll:    toAngle(roll),
+		Heading: toAngle(heading),
+	}, nil
+}
+
+func toAngle(radians float64) physic.Angle {
+	return physic.Angle(math.Round(radians * float64(physic.Radian)))
+}
+
+// Reads both sensors and computes the attitude. Set the calibrations on the
+// sensors first for accurate headings.
+type Compass struct {
+	accelerometer *Accelerometer
+	magnetometer  *Magnetometer
+	axes          CompassAxes
+}
+
+func NewCompass(accelerometer *Accelerometer, magnetometer *Magnetometer, axes CompassAxes) (*Compass, error) {
+	err := axes.Check()
+	if err != nil {
+		return nil, err
+	}
+	return &Compass{accelerometer: accelerometer, magnetometer: magnetometer, axes: axes}, nil
+}
+
+func (compass *Compass) Sense() (Attitude, error) {
+	xa, ya, za, err := compass.accelerometer.Sense()
+	if err != nil {
+		return Attitude{}, err
+	}
+	xm, ym, zm, err := compass.magnetometer.Sense()
+	if err != nil {
+		return Attitude{}, err
+	}
+	return ComputeAttitude(compass.axes, xa, ya, za, xm, ym, zm)
+}
@@ -0,0 +1,112 @@
+package lsm303
+
+import (
+	"math"
+	"periph.io/x/periph/conn/physic"
+	"testing"
+)
+
+// Simulates readings for the attitude, in degrees, with a field inclined 60
+// degrees down
+func simulateAttitude(axes CompassAxes, pitch, roll, heading float64) ([3]physic.Force, [3]MagneticFluxDensity) {
+	theta := pitch * math.Pi / 180
+	phi := roll * math.Pi / 180
+	psi := heading * math.Pi / 180
+	sinTheta, cosTheta := math.Sincos(theta)
+	sinPhi, cosPhi := math.Sincos(phi)
+	sinPsi, cosPsi := math.Sincos(psi)
+	// Rotates north, east, down into forward, right, down
+	rotation := [3][3]float64{
+		{cosTheta * cosPsi, cosTheta * sinPsi, -sinTheta},
+		{sinPhi*sinTheta*cosPsi - cosPhi*sinPsi, sinPhi*sinTheta*sinPsi + cosPhi*cosPsi, sinPhi * cosTheta},
+		{cosPhi*sinTheta*cosPsi + sinPhi*sinPsi, cosPhi*sinTheta*sinPsi - sinPhi*cosPsi, cosPhi * cosTheta},
+	}
+	gravity := float64(physic.EarthGravity)
+	acceleration := multiply3(rotation, [3]float64{0, 0, -gravity})
+	field := multiply3(rotation, [3]float64{20 * float64(Microtesla), 0, 34.64 * float64(Microtesla)})
+
+	var sensorAcceleration [3]physic.Force
+	var sensorField [3]MagneticFluxDensity
+	for i, axis := range [...]SensorAxis{axes.Forward, axes.Right, axes.Down} {
+		vector := axis.vector()
+		for j := range vector {
+			sensorAcceleration[j] += physic.Force(math.Round(vector[j] * acceleration[i]))
+			sensorField[j] += MagneticFluxDensity(math.Round(vector[j] * field[i]))
+		}
+	}
+	return sensorAcceleration, sensorField
+}
+
+// The difference between two angles in degrees, accounting for wrap around
+func angleDifference(a physic.Angle, degrees float64) float64 {
+	difference := math.Mod(float64(a)/float64(physic.Degree)-degrees, 360)
+	if difference > 180 {
+		difference -= 360
+	} else if difference < -180 {
+		difference += 360
+	}
+	return math.Abs(difference)
+}
+
+func TestComputeAttitude(t *testing.T) {
+	tests := []struct {
+		name                          string
+		axes                          CompassAxes
+		pitch, roll, heading          float64
+		expectedRoll, expectedHeading float64
+	}{
+		{"north", DefaultCompassAxes, 0, 0, 0, 0, 0},
+		{"east", DefaultCompassAxes, 0, 0, 90, 0, 90},
+		{"south", DefaultCompassAxes, 0, 0, 180, 0, 180},
+		{"west", DefaultCompassAxes, 0, 0, 270, 0, 270},
+		{"nose up", DefaultCompassAxes, 30, 0, 45, 0, 45},
+		{"nose down", DefaultCompassAxes, -45, 0, 315, 0, 315},
+		{"banked", DefaultCompassAxes, 10, -60, 200, -60, 200},
+		{"upside down", DefaultCompassAxes, 0, 180, 100, 180, 100},
+		{"nearly upside down", DefaultCompassAxes, 20, -170, 10, -170, 10},
+		{"nearly vertical", DefaultCompassAxes, 89.9, 30, 60, 30, 60},
+		// Roll and heading can't be told apart, so roll is folded into the
+		// heading
+		{"vertical", DefaultCompassAxes, 90, 0, 60, 0, 60},
+		{"vertical with roll", DefaultCompassAxes, 90, 20, 60, 0, 40},
+		{"vertical down", DefaultCompassAxes, -90, 0, 123, 0, 123},
+		{"Y forward", YForwardCompassAxes, 15, 25, 300, 25, 300},
+		{"upright", CompassAxes{SENSOR_AXIS_Z, SENSOR_AXIS_NEGATIVE_Y, SENSOR_AXIS_X}, -20, 5, 170, 5, 170},
+	}
+	for _, test := range tests {
+		acceleration, field := simulateAttitude(test.axes, test.pitch, test.roll, test.heading)
+		attitude, err := ComputeAttitude(test.axes, acceleration[0], acceleration[1], acceleration[2], field[0], field[1], field[2])
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if angleDifference(attitude.Pitch, test.pitch) > 0.01 ||
+			angleDifference(attitude.Roll, test.expectedRoll) > 0.01 ||
+			angleDifference(attitude.Heading, test.expectedHeading) > 0.01 {
+			t.Errorf("%s: expected pitch %v roll %v heading %v but was %v %v %v", test.name, test.pitch, test.expectedRoll, test.expectedHeading, attitude.Pitch, attitude.Roll, attitude.Heading)
+		}
+		if attitude.Heading < 0 || attitude.Heading >= physic.Theta {
+			t.Errorf("%s: heading %v out of range", test.name, attitude.Heading)
+		}
+	}
+}
+
+func TestComputeAttitudeErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		axes       CompassAxes
+		xa, ya, za physic.Force
+		xm, ym, zm MagneticFluxDensity
+	}{
+		{"left handed", CompassAxes{SENSOR_AXIS_X, SENSOR_AXIS_Y, SENSOR_AXIS_NEGATIVE_Z}, 0, 0, physic.EarthGravity, Gauss, 0, 0},
+		{"repeated axis", CompassAxes{SENSOR_AXIS_X, SENSOR_AXIS_X, SENSOR_AXIS_Z}, 0, 0, physic.EarthGravity, Gauss, 0, 0},
+		{"free fall", DefaultCompassAxes, 0, 0, 0, Gauss, 0, 0},
+		{"vertical field", DefaultCompassAxes, 0, 0, physic.EarthGravity, 0, 0, Gauss},
+	}
+	for _, test := range tests {
+		_, err := ComputeAttitude(test.axes, test.xa, test.ya, test.za, test.xm, test.ym, test.zm)
+		if err == nil {
+			t.Errorf("%s should fail", test.name)
+		}
+	}
+}
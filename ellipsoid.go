@@ -0,0 +1,264 @@
+package lsm303
+
+import (
+	"errors"
+	"math"
+)
+
+// The least squares ellipsoid fit is shared by the magnetometer and
+// accelerometer calibrations. The fit finds a center and a symmetric shape
+// matrix so that (p - center)' * shape * (p - center) = 1 for each point p.
+// The correction that maps the ellipsoid onto a sphere of radius r is
+// r * sqrt(shape).
+
+// A quadric needs 9 parameters
+const minimumEllipsoidPoints = 9
+
+func fitEllipsoid(points [][3]float64) ([3]float64, [3][3]float64, error) {
+	if len(points) < minimumEllipsoidPoints {
+		return [3]float64{}, [3][3]float64{}, errors.New("Not enough samples for an ellipsoid fit")
+	}
+
+	// Center and scale the points to keep the normal equations well
+	// conditioned
+	var mean [3]float64
+	for _, point := range points {
+		for i := range mean {
+			mean[i] += point[i]
+		}
+	}
+	for i := range mean {
+		mean[i] /= float64(len(points))
+	}
+	scale := 0.0
+	for _, point := range points {
+		for i := range mean {
+			scale += (point[i] - mean[i]) * (point[i] - mean[i])
+		}
+	}
+	scale = math.Sqrt(scale / float64(len(points)))
+	if scale == 0 {
+		return [3]float64{}, [3][3]float64{}, errors.New("Samples don't fit an ellipsoid")
+	}
+
+	// Fit a*x^2 + b*y^2 + c*z^2 + 2d*xy + 2e*xz + 2f*yz + 2g*x + 2h*y + 2i*z = 1
+	normal := make([][]float64, 9)
+	for i := range normal {
+		normal[i] = make([]float64, 10)
+	}
+	for _, point := range points {
+		x := (point[0] - mean[0]) / scale
+		y := (point[1] - mean[1]) / scale
+		z := (point[2] - mean[2]) / scale
+		row := [...]float64{x * x, y * y, z * z, 2 * x * y, 2 * x * z, 2 * y * z, 2 * x, 2 * y, 2 * z}
+		for i := range row {
+			for j := range row {
+				normal[i][j] += row[i] * row[j]
+			}
+			normal[i][9] += row[i]
+		}
+	}
+	parameters, err := solveLinear(normal)
+	if err != nil {
+		return [3]float64{}, [3][3]float64{}, errors.New("Samples don't fit an ellipsoid")
+	}
+
+	quadratic := [3][3]float64{
+		{parameters[0], parameters[3], parameters[4]},
+		{parameters[3], parameters[1], parameters[5]},
+		{parameters[4], parameters[5], parameters[2]},
+	}
+	linear := [3]float64{parameters[6], parameters[7], parameters[8]}
+	inverse, err := invert3(quadratic)
+	if err != nil {
+		return [3]float64{}, [3][3]float64{}, err
+	}
+	// x'Ax + 2v'x = 1 is centered at -A^-1 v, where it becomes
+	// y'Ay = 1 + c'Ac
+	var center [3]float64
+	for i := range center {
+		for j := range linear {
+			center[i] -= inverse[i][j] * linear[j]
+		}
+	}
+	constant := 1.0
+	for i := range center {
+		for j := range center {
+			constant += center[i] * quadratic[i][j] * center[j]
+		}
+	}
+	if constant <= 0 {
+		return [3]float64{}, [3][3]float64{}, errors.New("Samples don't fit an ellipsoid")
+	}
+
+	var shape [3][3]float64
+	for i := range shape {
+		for j := range shape[i] {
+			shape[i][j] = quadratic[i][j] / constant / (scale * scale)
+		}
+	}
+	values, _ := symmetricEigen(shape)
+	for _, value := range values {
+		if value <= 0 {
+			return [3]float64{}, [3][3]float64{}, errors.New("Samples don't fit an ellipsoid")
+		}
+	}
+	for i := range center {
+		center[i] = center[i]*scale + mean[i]
+	}
+	return center, shape, nil
+}
+
+// Returns the matrix that maps the ellipsoid onto a sphere of the given radius
+func ellipsoidCorrection(shape [3][3]float64, radius float64) [3][3]float64 {
+	values, vectors := symmetricEigen(shape)
+	var correction [3][3]float64
+	for i := range correction {
+		for j := range correction[i] {
+			for k := range values {
+				correction[i][j] += vectors[i][k] * math.Sqrt(values[k]) * vectors[j][k]
+			}
+			correction[i][j] *= radius
+		}
+	}
+	return correction
+}
+
+// The geometric mean of the ellipsoid's semi-axes. Correcting to this radius
+// preserves the volume.
+func ellipsoidRadius(shape [3][3]float64) float64 {
+	values, _ := symmetricEigen(shape)
+	return math.Pow(values[0]*values[1]*values[2], -1.0/6)
+}
+
+// The ratio of the shortest to the longest semi-axis, where 1 is a sphere
+func ellipsoidAxisRatio(shape [3][3]float64) float64 {
+	values, _ := symmetricEigen(shape)
+	minimum := math.Min(values[0], math.Min(values[1], values[2]))
+	maximum := math.Max(values[0], math.Max(values[1], values[2]))
+	// The semi-axes are 1 / sqrt(eigenvalue)
+	return math.Sqrt(minimum / maximum)
+}
+
+// The RMS distance of the corrected points from the sphere, as a fraction of
+// the radius
+func sphereResidual(points [][3]float64, center [3]float64, correction [3][3]float64, radius float64) float64 {
+	sum := 0.0
+	for _, point := range points {
+		corrected := multiply3(correction, [3]float64{point[0] - center[0], point[1] - center[1], point[2] - center[2]})
+		length := math.Sqrt(corrected[0]*corrected[0] + corrected[1]*corrected[1] + corrected[2]*corrected[2])
+		sum += (length - radius) * (length - radius)
+	}
+	return math.Sqrt(sum/float64(len(points))) / radius
+}
+
+func multiply3(matrix [3][3]float64, vector [3]float64) [3]float64 {
+	var result [3]float64
+	for i := range result {
+		for j := range vector {
+			result[i] += matrix[i][j] * vector[j]
+		}
+	}
+	return result
+}
+
+func invert3(matrix [3][3]float64) ([3][3]float64, error) {
+	m := matrix
+	determinant := m[0][0]*(m[1][1]*m[2][2]-m[1][2]*m[2][1]) -
+		m[0][1]*(m[1][0]*m[2][2]-m[1][2]*m[2][0]) +
+		m[0][2]*(m[1][0]*m[2][1]-m[1][1]*m[2][0])
+	if math.Abs(determinant) < 1e-12 {
+		return [3][3]float64{}, errors.New("Matrix is singular")
+	}
+	var inverse [3][3]float64
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			// The cofactor of (j, i), using cyclic indices to get the sign
+			// for free
+			a := m[(j+1)%3][(i+1)%3]
+			b := m[(j+1)%3][(i+2)%3]
+			c := m[(j+2)%3][(i+1)%3]
+			d := m[(j+2)%3][(i+2)%3]
+			inverse[i][j] = (a*d - b*c) / determinant
+		}
+	}
+	return inverse, nil
+}
+
+// Solves the augmented system of linear equations with Gaussian elimination.
+// The augmented matrix is modified.
+func solveLinear(augmented [][]float64) ([]float64, error) {
+	n := len(augmented)
+	for column := 0; column < n; column++ {
+		pivot := column
+		for row := column + 1; row < n; row++ {
+			if math.Abs(augmented[row][column]) > math.Abs(augmented[pivot][column]) {
+				pivot = row
+			}
+		}
+		if math.Abs(augmented[pivot][column]) < 1e-12 {
+			return nil, errors.New("Matrix is singular")
+		}
+		augmented[column], augmented[pivot] = augmented[pivot], augmented[column]
+		for row := column + 1; row < n; row++ {
+			factor := augmented[row][column] / augmented[column][column]
+			for k := column; k <= n; k++ {
+				augmented[row][k] -= factor * augmented[column][k]
+			}
+		}
+	}
+	result := make([]float64, n)
+	for row := n - 1; row >= 0; row-- {
+		sum := augmented[row][n]
+		for k := row + 1; k < n; k++ {
+			sum -= augmented[row][k] * result[k]
+		}
+		result[row] = sum / augmented[row][row]
+	}
+	return result, nil
+}
+
+// Jacobi eigenvalue algorithm. The eigenvectors are the columns.
+func symmetricEigen(matrix [3][3]float64) ([3]float64, [3][3]float64) {
+	a := matrix
+	vectors := [3][3]float64{{1, 0, 0}, {0, 1, 0}, {0, 0, 1}}
+	for sweep := 0; sweep < 50; sweep++ {
+		offDiagonal := a[0][1]*a[0][1] + a[0][2]*a[0][2] + a[1][2]*a[1][2]
+		if offDiagonal < 1e-30 {
+			break
+		}
+		for p := 0; p < 2; p++ {
+			for q := p + 1; q < 3; q++ {
+				if a[p][q] == 0 {
+					continue
+				}
+				theta := (a[q][q] - a[p][p]) / (2 * a[p][q])
+				t := 1 / (math.Abs(theta) + math.Sqrt(theta*theta+1))
+				if theta < 0 {
+					t = -t
+				}
+				c := 1 / math.Sqrt(t*t+1)
+				s := t * c
+				for k := 0; k < 3; k++ {
+					akp := a[k][p]
+					akq := a[k][q]
+					a[k][p] = c*akp - s*akq
+					a[k][q] = s*akp + c*akq
+				}
+				for k := 0; k < 3; k++ {
+					apk := a[p][k]
+					aqk := a[q][k]
+					a[p][k] = c*apk - s*aqk
+					a[q][k] = s*apk + c*aqk
+				}
+				for k := 0; k < 3; k++ {
+					vkp := vectors[k][p]
+					vkq := vectors[k][q]
+					vectors[k][p] = c*vkp - s*vkq
+					vectors[k][q] = s*vkp + c*vkq
+				}
+			}
+		}
+	}
+	return [3]float64{a[0][0], a[1][1], a[2][2]}, vectors
+}
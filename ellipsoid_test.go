@@ -0,0 +1,97 @@
+package lsm303
+
+import (
+	"math"
+	"testing"
+)
+
+func TestSymmetricEigen(t *testing.T) {
+	matrix := [3][3]float64{{4, 1, 0.5}, {1, 3, 0.2}, {0.5, 0.2, 2}}
+	values, vectors := symmetricEigen(matrix)
+	for k := range values {
+		vector := [3]float64{vectors[0][k], vectors[1][k], vectors[2][k]}
+		product := multiply3(matrix, vector)
+		for i := range product {
+			if math.Abs(product[i]-values[k]*vector[i]) > 1e-9 {
+				t.Fatalf("Bad eigenpair %v %v", values[k], vector)
+			}
+		}
+	}
+}
+
+func TestInvert3(t *testing.T) {
+	matrix := [3][3]float64{{2, 1, 0}, {0, 3, 1}, {1, 0, 4}}
+	inverse, err := invert3(matrix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			sum := 0.0
+			for k := 0; k < 3; k++ {
+				sum += matrix[i][k] * inverse[k][j]
+			}
+			expected := 0.0
+			if i == j {
+				expected = 1
+			}
+			if math.Abs(sum-expected) > 1e-12 {
+				t.Fatalf("Bad inverse %v", inverse)
+			}
+		}
+	}
+
+	_, err = invert3([3][3]float64{{1, 2, 3}, {2, 4, 6}, {0, 0, 1}})
+	if err == nil {
+		t.Fatal("Singular matrix should fail")
+	}
+}
+
+func TestFitEllipsoid(t *testing.T) {
+	distortion := [3][3]float64{{1.2, 0.1, -0.05}, {0.1, 0.8, 0.02}, {-0.05, 0.02, 1.0}}
+	center := [3]float64{30, -12, 7}
+	var points [][3]float64
+	for _, sample := range sphereSamples([3]float64{}, 10000) {
+		point := multiply3(distortion, [3]float64{float64(sample[0]) / 100, float64(sample[1]) / 100, float64(sample[2]) / 100})
+		for i := range point {
+			point[i] += center[i]
+		}
+		points = append(points, point)
+	}
+
+	fitCenter, shape, err := fitEllipsoid(points)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range center {
+		if math.Abs(fitCenter[i]-center[i]) > 1e-6 {
+			t.Fatalf("Expected center %v but was %v", center, fitCenter)
+		}
+	}
+	correction := ellipsoidCorrection(shape, 100)
+	residual := sphereResidual(points, fitCenter, correction, 100)
+	if residual > 1e-3 {
+		t.Fatalf("Residual too large %v", residual)
+	}
+	// A symmetric distortion is undone exactly
+	for i := range correction {
+		for j := range correction[i] {
+			product := 0.0
+			for k := range correction {
+				product += correction[i][k] * distortion[k][j]
+			}
+			expected := 0.0
+			if i == j {
+				expected = 1
+			}
+			if math.Abs(product-expected) > 1e-2 {
+				t.Fatalf("Correction %v doesn't undo the distortion", correction)
+			}
+		}
+	}
+
+	_, _, err = fitEllipsoid(points[:5])
+	if err == nil {
+		t.Fatal("Fit with too few points should fail")
+	}
+}
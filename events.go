@@ -0,0 +1,104 @@
+package lsm303
+
+import (
+	"context"
+	"errors"
+	"periph.io/x/periph/conn/gpio"
+	"sync"
+	"time"
+)
+
+// How often to check whether the context is done while waiting for an edge
+const eventPollInterval = 100 * time.Millisecond
+
+// An interrupt delivered through one of the INT1 or INT2 pins
+type AccelerometerEvent struct {
+	// Which pin the event came from
+	Interrupt AccelerometerInterrupt
+	// The decoded INTx_SOURCE_A of the interrupt generator on this pin
+	Source InterruptSource
+	// Any clicks, if click detection is routed to this pin
+	Clicks []ClickEvent
+}
+
+// Decodes the orientation, if the interrupt generator was configured with
+// ConfigureOrientation.
+func (source InterruptSource) Orientation() Orientation {
+	return decodeOrientation(source)
+}
+
+// Waits for edges on the interrupt pins given in AccelerometerOpts and sends
+// the decoded events on the returned channel. The channel is closed when the
+// context is done or a read fails. Don't change the click configuration while
+// this is running.
+func (accelerometer *Accelerometer) Events(ctx context.Context) (<-chan AccelerometerEvent, error) {
+	if accelerometer.pins[ACCELEROMETER_INTERRUPT_1] == nil && accelerometer.pins[ACCELEROMETER_INTERRUPT_2] == nil {
+		return nil, errors.New("No accelerometer interrupt pins configured")
+	}
+
+	events := make(chan AccelerometerEvent)
+	var waitGroup sync.WaitGroup
+	for i, pin := range accelerometer.pins {
+		if pin == nil {
+			continue
+		}
+		waitGroup.Add(1)
+		go func(interrupt AccelerometerInterrupt, pin gpio.PinIn) {
+			defer waitGroup.Done()
+			accelerometer.watchPin(ctx, interrupt, pin, events)
+		}(AccelerometerInterrupt(i), pin)
+	}
+	go func() {
+		waitGroup.Wait()
+		close(events)
+	}()
+	return events, nil
+}
+
+func (accelerometer *Accelerometer) watchPin(ctx context.Context, interrupt AccelerometerInterrupt, pin gpio.PinIn, events chan<- AccelerometerEvent) {
+	// A latched interrupt might already be active, in which case there won't
+	// be another edge until it's cleared
+	pending := pin.Read() == gpio.High
+	for {
+		if !pending {
+			select {
+			case <-ctx.Done():
+				return
+			default:
+			}
+			if !pin.WaitForEdge(eventPollInterval) {
+				continue
+			}
+		}
+		pending = false
+
+		event, err := accelerometer.readEvent(interrupt)
+		if err != nil {
+			return
+		}
+		if !event.Source.Active && len(event.Clicks) == 0 {
+			continue
+		}
+		select {
+		case events <- event:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Reads the sources that are routed to the pin
+func (accelerometer *Accelerometer) readEvent(interrupt AccelerometerInterrupt) (AccelerometerEvent, error) {
+	source, err := accelerometer.ReadInterruptSource(interrupt)
+	if err != nil {
+		return AccelerometerEvent{}, err
+	}
+	event := AccelerometerEvent{Interrupt: interrupt, Source: source}
+	if accelerometer.clickEnabled && accelerometer.clickInterrupt == interrupt {
+		event.Clicks, err = accelerometer.ReadClicks()
+		if err != nil {
+			return AccelerometerEvent{}, err
+		}
+	}
+	return event, nil
+}
@@ -0,0 +1,76 @@
+package lsm303
+
+import (
+	"context"
+	"encoding/binary"
+	"periph.io/x/periph/conn/gpio"
+	"periph.io/x/periph/conn/gpio/gpiotest"
+	"periph.io/x/periph/conn/i2c"
+	"periph.io/x/periph/conn/i2c/i2ctest"
+	"periph.io/x/periph/conn/mmr"
+	"testing"
+)
+
+func TestEvents(t *testing.T) {
+	scenario := &i2ctest.Playback{
+		Ops: []i2ctest.IO{
+			// Free-fall on INT1
+			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_INT1_SOURCE_A}, R: []byte{0x55}},
+			// Click on INT2, with nothing from the INT2 generator
+			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_INT2_SOURCE_A}, R: []byte{0}},
+			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_CLICK_SRC_A}, R: []byte{0x54}},
+		},
+	}
+
+	pin1 := &gpiotest.Pin{N: "INT1", EdgesChan: make(chan gpio.Level, 1)}
+	pin2 := &gpiotest.Pin{N: "INT2", EdgesChan: make(chan gpio.Level, 1)}
+	accelerometer := &Accelerometer{
+		mmr: mmr.Dev8{
+			Conn:  &i2c.Dev{Bus: scenario, Addr: uint16(ACCELEROMETER_ADDRESS)},
+			Order: binary.BigEndian,
+		},
+		pins:           [...]gpio.PinIn{pin1, pin2},
+		clickEnabled:   true,
+		clickInterrupt: ACCELEROMETER_INTERRUPT_2,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	events, err := accelerometer.Events(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pin1.EdgesChan <- gpio.High
+	event := <-events
+	if event.Interrupt != ACCELEROMETER_INTERRUPT_1 {
+		t.Fatalf("Expected INT1 but was %v", event.Interrupt)
+	}
+	expected := InterruptSource{Active: true, Events: INTERRUPT_LOW}
+	if event.Source != expected {
+		t.Fatalf("Expected %+v but was %+v", expected, event.Source)
+	}
+	if len(event.Clicks) != 0 {
+		t.Fatal("INT1 shouldn't have clicks")
+	}
+
+	pin2.EdgesChan <- gpio.High
+	event = <-events
+	if event.Interrupt != ACCELEROMETER_INTERRUPT_2 {
+		t.Fatalf("Expected INT2 but was %v", event.Interrupt)
+	}
+	if len(event.Clicks) != 1 || event.Clicks[0] != (ClickEvent{Axis: ACCELEROMETER_AXIS_Z}) {
+		t.Fatalf("Expected a +Z single click but was %v", event.Clicks)
+	}
+
+	cancel()
+	for range events {
+	}
+}
+
+func TestEventsWithoutPins(t *testing.T) {
+	accelerometer := &Accelerometer{}
+	_, err := accelerometer.Events(context.Background())
+	if err == nil {
+		t.Fatal("Events without pins should fail")
+	}
+}
@@ -1,42 +1,66 @@
-package lsm303
+package main
 
 import (
 	"fmt"
+	lsm303 "github.com/bskari/go-lsm303"
 	"log"
+	"periph.io/x/periph/conn/i2c/i2creg"
+	"periph.io/x/periph/host"
 	"time"
-	lsm303 "github.com/bskari/go-lsm303"
 )
 
 func main() {
-	accelerometer, err := NewAccelerometer()
+	_, err := host.Init()
+	if err != nil {
+		log.Fatal(err)
+	}
+	bus, err := i2creg.Open("")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer bus.Close()
+
+	accelerometer, err := lsm303.NewAccelerometer(bus, &lsm303.DefaultAccelerometerOpts)
 	if err != nil {
 		log.Fatal("Couldn't connect to accelerometer")
 	}
 
-	magnetometer, err != NewMagnetometer()
+	magnetometer, err := lsm303.NewMagnetometer(bus, &lsm303.DefaultMagnetometerOpts)
 	if err != nil {
 		log.Fatal("Couldn't connect to magnetometer")
 	}
 
 	for {
-		xa, ya, za := accelerometer.Sense()
+		xa, ya, za, err := accelerometer.Sense()
+		if err != nil {
+			log.Fatal(err)
+		}
 		fmt.Printf("accel x:%v y:%v z:%v\n", xa, ya, za)
-		xa, ya, za = accelerometer.SenseRaw()
-		fmt.Printf("raw accel x:%v y:%v z:%v\n", xa, ya, za)
+		rawXa, rawYa, rawZa, err := accelerometer.SenseRaw()
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("raw accel x:%v y:%v z:%v\n", rawXa, rawYa, rawZa)
 
-		// The periph.io has units defined for many things, but not for
-		// magnetometer flux, so we only have SenseRaw
-		xm, ym, zm := magnetometer.SenseRaw()
-		fmt.Printf("raw mag x:%v y:%v z:%v\n", xm, ym, zm)
+		xm, ym, zm, err := magnetometer.Sense()
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("mag x:%v y:%v z:%v\n", xm, ym, zm)
+		rawXm, rawYm, rawZm, err := magnetometer.SenseRaw()
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("raw mag x:%v y:%v z:%v\n", rawXm, rawYm, rawZm)
 
 		time.Sleep(time.Second * 1)
 	}
 
 	// Examples for setting options
 	/*
-	accelerometer.SetRange(ACCELEROMETER_RANGE_16G)
-	accelerometer.SetMode(ACCELEROMETER_MODE_LOW_POWER)
-	magnetometer.SetGain(MAGNETOMETER_GAIN_5_6)
-	magnetometer.SetRate(MAGNETOMETER_RATE_75)
+		accelerometer.SetRange(lsm303.ACCELEROMETER_RANGE_16G)
+		accelerometer.SetMode(lsm303.ACCELEROMETER_MODE_LOW_POWER)
+		magnetometer.SetGain(lsm303.MAGNETOMETER_GAIN_5_6)
+		magnetometer.SetRate(lsm303.MAGNETOMETER_RATE_75)
 	*/
 }
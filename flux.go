@@ -0,0 +1,30 @@
+package lsm303
+
+import (
+	"strconv"
+)
+
+// MagneticFluxDensity is a measurement of magnetic flux density, stored in
+// nanotesla. periph.io doesn't have a unit for this, so it's defined here.
+type MagneticFluxDensity int64
+
+const (
+	Nanotesla  MagneticFluxDensity = 1
+	Microtesla MagneticFluxDensity = 1000 * Nanotesla
+	Millitesla MagneticFluxDensity = 1000 * Microtesla
+	Tesla      MagneticFluxDensity = 1000 * Millitesla
+	Gauss      MagneticFluxDensity = 100 * Microtesla
+)
+
+// Formats the value in microtesla, e.g. "48.123µT"
+func (flux MagneticFluxDensity) String() string {
+	return strconv.FormatFloat(flux.Microtesla(), 'f', -1, 64) + "µT"
+}
+
+func (flux MagneticFluxDensity) Microtesla() float64 {
+	return float64(flux) / float64(Microtesla)
+}
+
+func (flux MagneticFluxDensity) Gauss() float64 {
+	return float64(flux) / float64(Gauss)
+}
@@ -0,0 +1,17 @@
+package lsm303
+
+import (
+	"testing"
+)
+
+func TestMagneticFluxDensity(t *testing.T) {
+	if Gauss.Microtesla() != 100 {
+		t.Errorf("1 gauss should be 100 µT but was %v", Gauss.Microtesla())
+	}
+	if (50 * Microtesla).Gauss() != 0.5 {
+		t.Errorf("50 µT should be 0.5 gauss but was %v", (50 * Microtesla).Gauss())
+	}
+	if s := (48123 * Nanotesla).String(); s != "48.123µT" {
+		t.Errorf("Bad string %v", s)
+	}
+}
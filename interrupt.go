@@ -0,0 +1,334 @@
+package lsm303
+
+import (
+	"errors"
+	"math"
+	"periph.io/x/periph/conn/physic"
+	"time"
+)
+
+// The accelerometer has 2 interrupt generators. Generator 1 is routed to the
+// INT1 pin and generator 2 to the INT2 pin.
+type AccelerometerInterrupt int
+
+const (
+	ACCELEROMETER_INTERRUPT_1 AccelerometerInterrupt = iota
+	ACCELEROMETER_INTERRUPT_2
+)
+
+func (interrupt AccelerometerInterrupt) String() string {
+	return [...]string{"INT1", "INT2"}[interrupt]
+}
+
+// Interrupt 2 registers are at the same offsets as interrupt 1
+func (interrupt AccelerometerInterrupt) register(interrupt1Register uint8) uint8 {
+	return interrupt1Register + uint8(interrupt)*(ACCELEROMETER_INT2_CFG_A-ACCELEROMETER_INT1_CFG_A)
+}
+
+// A bit mask of interrupt events, in the same order as INTx_CFG_A and
+// INTx_SOURCE_A
+type InterruptEvents uint8
+
+const (
+	INTERRUPT_X_LOW  InterruptEvents = 1 << 0
+	INTERRUPT_X_HIGH InterruptEvents = 1 << 1
+	INTERRUPT_Y_LOW  InterruptEvents = 1 << 2
+	INTERRUPT_Y_HIGH InterruptEvents = 1 << 3
+	INTERRUPT_Z_LOW  InterruptEvents = 1 << 4
+	INTERRUPT_Z_HIGH InterruptEvents = 1 << 5
+	INTERRUPT_LOW                    = INTERRUPT_X_LOW | INTERRUPT_Y_LOW | INTERRUPT_Z_LOW
+	INTERRUPT_HIGH                   = INTERRUPT_X_HIGH | INTERRUPT_Y_HIGH | INTERRUPT_Z_HIGH
+)
+
+func (events InterruptEvents) Has(event InterruptEvents) bool {
+	return events&event == event
+}
+
+func (events InterruptEvents) String() string {
+	result := ""
+	for i, name := range [...]string{"XL", "XH", "YL", "YH", "ZL", "ZH"} {
+		if events.Has(1 << i) {
+			if result != "" {
+				result += "|"
+			}
+			result += name
+		}
+	}
+	if result == "" {
+		return "none"
+	}
+	return result
+}
+
+// How the enabled events are combined to trigger the interrupt
+type InterruptCombination int
+
+const (
+	// Any enabled event triggers the interrupt
+	INTERRUPT_COMBINATION_OR InterruptCombination = iota
+	// Triggers when the orientation changes
+	INTERRUPT_COMBINATION_6D_MOVEMENT
+	// All enabled events must happen at the same time
+	INTERRUPT_COMBINATION_AND
+	// Triggers while in a known orientation
+	INTERRUPT_COMBINATION_6D_POSITION
+)
+
+func (combination InterruptCombination) String() string {
+	return [...]string{"OR", "6D movement", "AND", "6D position"}[combination]
+}
+
+type InterruptConfig struct {
+	Events      InterruptEvents
+	Combination InterruptCombination
+	// High events trigger above this and low events below it. This is scaled
+	// by the current range and rewritten whenever the range changes.
+	Threshold physic.Force
+	// How long the event needs to last. This is scaled by the current data
+	// rate and rewritten whenever the data rate changes.
+	Duration time.Duration
+	// Keep the interrupt active until the source is read
+	Latch bool
+	// In the 6D combinations, ignore the Z axis
+	FourD bool
+}
+
+// The decoded contents of INTx_SOURCE_A
+type InterruptSource struct {
+	// At least one interrupt event has happened
+	Active bool
+	Events InterruptEvents
+}
+
+// Configures an interrupt generator. Setting no events disables it.
+func (accelerometer *Accelerometer) ConfigureInterrupt(interrupt AccelerometerInterrupt, config InterruptConfig) error {
+	if interrupt != ACCELEROMETER_INTERRUPT_1 && interrupt != ACCELEROMETER_INTERRUPT_2 {
+		return errors.New("Unknown accelerometer interrupt")
+	}
+	threshold, err := getInterruptThreshold(config.Threshold, accelerometer.range_)
+	if err != nil {
+		return err
+	}
+	duration, err := getInterruptDuration(config.Duration, accelerometer.rate)
+	if err != nil {
+		return err
+	}
+
+	configuration := (uint8(config.Combination&0x03) << 6) | uint8(config.Events&0x3F)
+	err = accelerometer.mmr.WriteUint8(interrupt.register(ACCELEROMETER_INT1_THS_A), threshold)
+	if err != nil {
+		return err
+	}
+	err = accelerometer.mmr.WriteUint8(interrupt.register(ACCELEROMETER_INT1_DURATION_A), duration)
+	if err != nil {
+		return err
+	}
+	err = accelerometer.mmr.WriteUint8(interrupt.register(ACCELEROMETER_INT1_CFG_A), configuration)
+	if err != nil {
+		return err
+	}
+
+	// LIR_INTx and D4D_INTx are next to each other
+	latchAndFourD := uint8(0)
+	if config.Latch {
+		latchAndFourD |= 0b10
+	}
+	if config.FourD {
+		latchAndFourD |= 0b01
+	}
+	enabled := uint8(0)
+	if config.Events != 0 {
+		enabled = 1
+	}
+	if interrupt == ACCELEROMETER_INTERRUPT_1 {
+		err = writeBits(&accelerometer.mmr, ACCELEROMETER_CTRL_REG5_A, 2, 2, latchAndFourD)
+		if err != nil {
+			return err
+		}
+		// I1_AOI1
+		err = writeBits(&accelerometer.mmr, ACCELEROMETER_CTRL_REG3_A, 1, 6, enabled)
+	} else {
+		err = writeBits(&accelerometer.mmr, ACCELEROMETER_CTRL_REG5_A, 2, 0, latchAndFourD)
+		if err != nil {
+			return err
+		}
+		// I2_INT2
+		err = writeBits(&accelerometer.mmr, ACCELEROMETER_CTRL_REG6_A, 1, 5, enabled)
+	}
+	if err != nil {
+		return err
+	}
+	time.Sleep(time.Millisecond * 20)
+
+	if config.Events == 0 {
+		accelerometer.interruptThresholds[interrupt] = 0
+		accelerometer.interruptDurations[interrupt] = 0
+	} else {
+		accelerometer.interruptThresholds[interrupt] = config.Threshold
+		accelerometer.interruptDurations[interrupt] = config.Duration
+	}
+
+	return nil
+}
+
+// Rewrites the interrupt and click thresholds for the current range.
+// Thresholds that are too large for the range are clamped to the largest
+// value.
+func (accelerometer *Accelerometer) rescaleThresholds() error {
+	for i, threshold := range accelerometer.interruptThresholds {
+		if threshold == 0 {
+			continue
+		}
+		value, err := getInterruptThreshold(threshold, accelerometer.range_)
+		if err != nil {
+			value = 127
+		}
+		interrupt := AccelerometerInterrupt(i)
+		err = accelerometer.mmr.WriteUint8(interrupt.register(ACCELEROMETER_INT1_THS_A), value)
+		if err != nil {
+			return err
+		}
+	}
+	if accelerometer.clickEnabled && accelerometer.click.Threshold != 0 {
+		value, err := getClickThreshold(accelerometer.click.Threshold, accelerometer.range_)
+		if err != nil {
+			value = 127
+		}
+		err = accelerometer.mmr.WriteUint8(ACCELEROMETER_CLICK_THS_A, value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// A register and the value to write to it
+type registerWrite struct {
+	register uint8
+	value    uint8
+}
+
+// Converts the configured durations to sample counts for the data rate.
+// Returns an error if any of them don't fit, so that the caller can leave the
+// data rate unchanged.
+func (accelerometer *Accelerometer) getDurationWrites(rate AccelerometerDataRate) ([]registerWrite, error) {
+	var writes []registerWrite
+	// Nothing is sampled while powered down, so the durations are rewritten
+	// when the data rate is set again
+	if rate == ACCELEROMETER_RATE_POWER_DOWN {
+		return writes, nil
+	}
+	for i, duration := range accelerometer.interruptDurations {
+		if duration == 0 {
+			continue
+		}
+		value, err := getInterruptDuration(duration, rate)
+		if err != nil {
+			return nil, err
+		}
+		interrupt := AccelerometerInterrupt(i)
+		writes = append(writes, registerWrite{interrupt.register(ACCELEROMETER_INT1_DURATION_A), value})
+	}
+	if accelerometer.clickEnabled {
+		clickWrites, err := getClickTimeWrites(accelerometer.click, rate)
+		if err != nil {
+			return nil, err
+		}
+		writes = append(writes, clickWrites...)
+	}
+	return writes, nil
+}
+
+// Reads which events triggered the interrupt. If the interrupt is latched,
+// this also clears it.
+func (accelerometer *Accelerometer) ReadInterruptSource(interrupt AccelerometerInterrupt) (InterruptSource, error) {
+	if interrupt != ACCELEROMETER_INTERRUPT_1 && interrupt != ACCELEROMETER_INTERRUPT_2 {
+		return InterruptSource{}, errors.New("Unknown accelerometer interrupt")
+	}
+	value, err := accelerometer.mmr.ReadUint8(interrupt.register(ACCELEROMETER_INT1_SOURCE_A))
+	if err != nil {
+		return InterruptSource{}, err
+	}
+	return decodeInterruptSource(value), nil
+}
+
+// Configures interrupt 1 to detect free-fall, i.e. all axes staying below the
+// threshold for at least minDuration. The data sheet recommends a threshold
+// around 350 mG and a duration around 30 ms. The interrupt is latched so that
+// short drops aren't missed.
+func (accelerometer *Accelerometer) ConfigureFreeFall(threshold physic.Force, minDuration time.Duration) error {
+	return accelerometer.ConfigureInterrupt(ACCELEROMETER_INTERRUPT_1, InterruptConfig{
+		Events:      INTERRUPT_LOW,
+		Combination: INTERRUPT_COMBINATION_AND,
+		Threshold:   threshold,
+		Duration:    minDuration,
+		Latch:       true,
+	})
+}
+
+// Returns whether a free-fall has happened since the last call. This clears
+// the interrupt.
+func (accelerometer *Accelerometer) ReadFreeFall() (bool, error) {
+	source, err := accelerometer.ReadInterruptSource(ACCELEROMETER_INTERRUPT_1)
+	if err != nil {
+		return false, err
+	}
+	return source.Active && source.Events.Has(INTERRUPT_LOW), nil
+}
+
+func decodeInterruptSource(value uint8) InterruptSource {
+	return InterruptSource{
+		Active: readBits(uint32(value), 1, 6) == 1,
+		Events: InterruptEvents(readBits(uint32(value), 6, 0)),
+	}
+}
+
+// Converts a threshold to the 7 bit register value for the range
+func getInterruptThreshold(threshold physic.Force, range_ AccelerometerRange) (uint8, error) {
+	// The data sheet gives the LSB in mg
+	var milliG int64
+	switch range_ {
+	case ACCELEROMETER_RANGE_2G:
+		milliG = 16
+	case ACCELEROMETER_RANGE_4G:
+		milliG = 32
+	case ACCELEROMETER_RANGE_8G:
+		milliG = 62
+	case ACCELEROMETER_RANGE_16G:
+		milliG = 186
+	default:
+		return 0, errors.New("Unknown accelerometer range")
+	}
+	if threshold < 0 {
+		return 0, errors.New("Interrupt threshold must not be negative")
+	}
+	lsb := float64(physic.EarthGravity) * float64(milliG) / 1000
+	value := math.Round(float64(threshold) / lsb)
+	if value > 127 {
+		return 0, errors.New("Interrupt threshold is too large for range " + range_.String())
+	}
+	return uint8(value), nil
+}
+
+// Converts a duration to the 7 bit register value for the data rate
+func getInterruptDuration(duration time.Duration, rate AccelerometerDataRate) (uint8, error) {
+	return getSampleCount(duration, rate, 127)
+}
+
+// Converts a duration to a number of samples at the data rate
+func getSampleCount(duration time.Duration, rate AccelerometerDataRate, maximum uint8) (uint8, error) {
+	if duration < 0 {
+		return 0, errors.New("Duration must not be negative")
+	}
+	if duration == 0 {
+		return 0, nil
+	}
+	if rate <= ACCELEROMETER_RATE_POWER_DOWN || rate > ACCELEROMETER_RATE_5376_LOW_POWER {
+		return 0, errors.New("Duration requires a data rate")
+	}
+	value := math.Round(duration.Seconds() * float64(rate.Frequency()) / float64(physic.Hertz))
+	if value > float64(maximum) {
+		return 0, errors.New("Duration " + duration.String() + " is too long for data rate " + rate.String())
+	}
+	return uint8(value), nil
+}
@@ -0,0 +1,199 @@
+package lsm303
+
+import (
+	"encoding/binary"
+	"periph.io/x/periph/conn/i2c"
+	"periph.io/x/periph/conn/i2c/i2ctest"
+	"periph.io/x/periph/conn/mmr"
+	"periph.io/x/periph/conn/physic"
+	"testing"
+	"time"
+)
+
+func TestConfigureInterrupt(t *testing.T) {
+	scenario := &i2ctest.Playback{
+		Ops: []i2ctest.IO{
+			// 0.5 G / 32 mG = 16
+			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_INT2_THS_A, 16}, R: []byte{}},
+			// 100 ms at 100 Hz = 10
+			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_INT2_DURATION_A, 10}, R: []byte{}},
+			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_INT2_CFG_A, 0x2A}, R: []byte{}},
+			// Latch
+			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_CTRL_REG5_A}, R: []byte{0x40}},
+			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_CTRL_REG5_A, 0x42}, R: []byte{}},
+			// Route to the INT2 pin
+			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_CTRL_REG6_A}, R: []byte{0}},
+			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_CTRL_REG6_A, 0x20}, R: []byte{}},
+			// Read the source
+			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_INT2_SOURCE_A}, R: []byte{0x48}},
+		},
+	}
+
+	accelerometer := &Accelerometer{
+		mmr: mmr.Dev8{
+			Conn:  &i2c.Dev{Bus: scenario, Addr: uint16(ACCELEROMETER_ADDRESS)},
+			Order: binary.BigEndian,
+		},
+		range_: ACCELEROMETER_RANGE_4G,
+		mode:   ACCELEROMETER_MODE_NORMAL,
+		rate:   ACCELEROMETER_RATE_100,
+	}
+
+	err := accelerometer.ConfigureInterrupt(ACCELEROMETER_INTERRUPT_2, InterruptConfig{
+		Events:      INTERRUPT_HIGH,
+		Combination: INTERRUPT_COMBINATION_OR,
+		Threshold:   physic.EarthGravity / 2,
+		Duration:    100 * time.Millisecond,
+		Latch:       true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	source, err := accelerometer.ReadInterruptSource(ACCELEROMETER_INTERRUPT_2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := InterruptSource{Active: true, Events: INTERRUPT_Y_HIGH}
+	if source != expected {
+		t.Fatalf("Expected %+v but was %+v", expected, source)
+	}
+}
+
+func TestFreeFall(t *testing.T) {
+	scenario := &i2ctest.Playback{
+		Ops: []i2ctest.IO{
+			// 350 mG / 16 mG = 22
+			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_INT1_THS_A, 22}, R: []byte{}},
+			// 30 ms at 100 Hz = 3
+			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_INT1_DURATION_A, 3}, R: []byte{}},
+			// AND of all low events
+			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_INT1_CFG_A, 0x95}, R: []byte{}},
+			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_CTRL_REG5_A}, R: []byte{0}},
+			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_CTRL_REG5_A, 0x08}, R: []byte{}},
+			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_CTRL_REG3_A}, R: []byte{0}},
+			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_CTRL_REG3_A, 0x40}, R: []byte{}},
+			// Falling
+			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_INT1_SOURCE_A}, R: []byte{0x55}},
+			// Not falling
+			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_INT1_SOURCE_A}, R: []byte{0x15}},
+		},
+	}
+
+	accelerometer := &Accelerometer{
+		mmr: mmr.Dev8{
+			Conn:  &i2c.Dev{Bus: scenario, Addr: uint16(ACCELEROMETER_ADDRESS)},
+			Order: binary.BigEndian,
+		},
+		range_: ACCELEROMETER_RANGE_2G,
+		mode:   ACCELEROMETER_MODE_NORMAL,
+		rate:   ACCELEROMETER_RATE_100,
+	}
+
+	err := accelerometer.ConfigureFreeFall(physic.EarthGravity*35/100, 30*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	falling, err := accelerometer.ReadFreeFall()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !falling {
+		t.Fatal("Should be falling")
+	}
+	falling, err = accelerometer.ReadFreeFall()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if falling {
+		t.Fatal("Should not be falling")
+	}
+}
+
+func TestInterruptDurationFollowsDataRate(t *testing.T) {
+	scenario := &i2ctest.Playback{
+		Ops: []i2ctest.IO{
+			// Set 10 Hz
+			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_CTRL_REG1_A}, R: []byte{0x57}},
+			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_CTRL_REG1_A, 0x27}, R: []byte{}},
+			// 1 s and 100 ms at 10 Hz
+			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_INT1_DURATION_A, 10}, R: []byte{}},
+			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_INT2_DURATION_A, 1}, R: []byte{}},
+		},
+	}
+
+	accelerometer := &Accelerometer{
+		mmr: mmr.Dev8{
+			Conn:  &i2c.Dev{Bus: scenario, Addr: uint16(ACCELEROMETER_ADDRESS)},
+			Order: binary.BigEndian,
+		},
+		range_:             ACCELEROMETER_RANGE_2G,
+		mode:               ACCELEROMETER_MODE_NORMAL,
+		rate:               ACCELEROMETER_RATE_100,
+		interruptDurations: [2]time.Duration{time.Second, 100 * time.Millisecond},
+	}
+
+	err := accelerometer.SetDataRate(ACCELEROMETER_RATE_10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 1 s is 200 samples at 200 Hz, which doesn't fit, so nothing is written
+	err = accelerometer.SetDataRate(ACCELEROMETER_RATE_200)
+	if err == nil {
+		t.Fatal("A duration that doesn't fit should be rejected")
+	}
+	if accelerometer.rate != ACCELEROMETER_RATE_10 {
+		t.Fatalf("Expected 10 Hz but was %v", accelerometer.rate)
+	}
+	err = scenario.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGetInterruptThreshold(t *testing.T) {
+	tests := []struct {
+		threshold physic.Force
+		range_    AccelerometerRange
+		expected  uint8
+	}{
+		{0, ACCELEROMETER_RANGE_2G, 0},
+		{physic.EarthGravity, ACCELEROMETER_RANGE_2G, 63},
+		{physic.EarthGravity, ACCELEROMETER_RANGE_4G, 31},
+		{physic.EarthGravity, ACCELEROMETER_RANGE_8G, 16},
+		{physic.EarthGravity, ACCELEROMETER_RANGE_16G, 5},
+	}
+	for _, test := range tests {
+		value, err := getInterruptThreshold(test.threshold, test.range_)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if value != test.expected {
+			t.Errorf("getInterruptThreshold(%v, %v) should be %v but was %v", test.threshold, test.range_, test.expected, value)
+		}
+	}
+
+	_, err := getInterruptThreshold(3*physic.EarthGravity, ACCELEROMETER_RANGE_2G)
+	if err == nil {
+		t.Error("Threshold above the range should be rejected")
+	}
+}
+
+func TestGetInterruptDuration(t *testing.T) {
+	value, err := getInterruptDuration(50*time.Millisecond, ACCELEROMETER_RATE_400)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value != 20 {
+		t.Errorf("Expected 20 but was %v", value)
+	}
+
+	_, err = getInterruptDuration(time.Second, ACCELEROMETER_RATE_400)
+	if err == nil {
+		t.Error("Duration above 127 samples should be rejected")
+	}
+	_, err = getInterruptDuration(time.Second, ACCELEROMETER_RATE_POWER_DOWN)
+	if err == nil {
+		t.Error("Duration should be rejected when powered down")
+	}
+}
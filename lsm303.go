@@ -1,9 +1,11 @@
 package lsm303
 
 import (
+	"context"
 	"encoding/binary"
 	"errors"
 	"log"
+	"periph.io/x/periph/conn/gpio"
 	"periph.io/x/periph/conn/i2c"
 	"periph.io/x/periph/conn/mmr"
 	"periph.io/x/periph/conn/physic"
@@ -14,34 +16,82 @@ import (
 type AccelerometerOpts struct {
 	Range AccelerometerRange
 	Mode  AccelerometerMode
+	// Unset means ACCELEROMETER_RATE_100
+	Rate AccelerometerDataRate
+	// Unset means ACCELEROMETER_AXES_ALL
+	Axes AccelerometerAxes
+	// Restore the default register values before applying these options
+	Reset bool
+	// Automatic range switching, disabled by default
+	AutoRange AccelerometerAutoRange
+	// ACCELEROMETER_BYTE_ORDER_DEVICE keeps whatever the device is set to
+	ByteOrder AccelerometerByteOrder
+	// Optional pins connected to INT1 and INT2, used by Events
+	Interrupt1Pin gpio.PinIn
+	Interrupt2Pin gpio.PinIn
 }
 
 // DefaultAccelerometerOpts is the recommended default options.
 var DefaultAccelerometerOpts = AccelerometerOpts{
 	Range: ACCELEROMETER_RANGE_4G,
 	Mode:  ACCELEROMETER_MODE_NORMAL,
+	Rate:  ACCELEROMETER_RATE_100,
+	Axes:  ACCELEROMETER_AXES_ALL,
 }
 
 // New accelerometer opens a handle to an LSM303 accelerometer sensor.
 func NewAccelerometer(bus i2c.Bus, opts *AccelerometerOpts) (*Accelerometer, error) {
+	rate := opts.Rate.orDefault()
+	axes := opts.Axes.orDefault()
 	device := &Accelerometer{
 		mmr: mmr.Dev8{
 			Conn: &i2c.Dev{Bus: bus, Addr: uint16(ACCELEROMETER_ADDRESS)},
-			// I don't think we ever access more than 1 byte at once, so
+			// Samples are read with readBurst, which honors the BLE bit, so
 			// this is irrelevant
 			Order: binary.BigEndian,
 		},
 		range_: opts.Range,
 		mode:   opts.Mode,
+		rate:   rate,
+		axes:   axes,
+		pins:   [...]gpio.PinIn{opts.Interrupt1Pin, opts.Interrupt2Pin},
 	}
 
-	// Enable the accelerometer 100 Hz, 0x57 = 0b01010111
+	err := checkDataRate(rate, opts.Mode)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.Reset {
+		err = device.Reset()
+		if err != nil {
+			return nil, err
+		}
+		// Reset clears the cached settings
+		device.range_ = opts.Range
+		device.mode = opts.Mode
+		device.rate = rate
+		device.axes = axes
+	}
+
+	// The interrupt pins are active high
+	for _, pin := range device.pins {
+		if pin == nil {
+			continue
+		}
+		err = pin.In(gpio.PullNoChange, gpio.RisingEdge)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Enable the accelerometer, e.g. 100 Hz = 0x57 = 0b01010111
 	// Bits 0-2 = X, Y, Z enable
 	// Bit 3 = low power mode
 	// Bits 4-7 = speed, 0 = power down, 1-7 = 1 10 25 50 100 200 400 Hz, 8 = low
 	//   power mode 1.62 khZ, 9 = normal 1.34 kHz / low power 5.376 kHz
-	// TODO: Allow the user to set the Hz and toggle axes
-	err := device.mmr.WriteUint8(ACCELEROMETER_CTRL_REG1_A, 0x57)
+	lowPower := uint8((opts.Mode & 0x02) >> 1)
+	err = device.mmr.WriteUint8(ACCELEROMETER_CTRL_REG1_A, (rate.registerValue()<<4)|(lowPower<<3)|uint8(device.axes))
 	if err != nil {
 		return nil, err
 	}
@@ -54,8 +104,28 @@ func NewAccelerometer(bus i2c.Bus, opts *AccelerometerOpts) (*Accelerometer, err
 		return nil, errors.New("No LSM303 detected")
 	}
 
+	// Enable block data update so that the high and low bytes are always from
+	// the same sample
+	err = writeBits(&device.mmr, ACCELEROMETER_CTRL_REG4_A, 1, 7, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.ByteOrder == ACCELEROMETER_BYTE_ORDER_DEVICE {
+		_, err = device.GetByteOrder()
+	} else {
+		err = device.SetByteOrder(opts.ByteOrder)
+	}
+	if err != nil {
+		return nil, err
+	}
+
 	device.SetRange(opts.Range)
 	device.SetMode(opts.Mode)
+	err = device.SetAutoRange(opts.AutoRange)
+	if err != nil {
+		return nil, err
+	}
 
 	return device, nil
 }
@@ -65,52 +135,165 @@ type Accelerometer struct {
 	mmr    mmr.Dev8
 	range_ AccelerometerRange
 	mode   AccelerometerMode
+	rate   AccelerometerDataRate
+	axes   AccelerometerAxes
+	// Whether the BLE bit is set
+	bigEndian bool
+	autoRange AccelerometerAutoRange
+	// When the samples started fitting in the next lower range
+	belowSince time.Time
+	// Indexed by AccelerometerInterrupt
+	pins [2]gpio.PinIn
+	// Which pin click events are routed to, if any
+	clickEnabled   bool
+	clickInterrupt AccelerometerInterrupt
+	// The configured thresholds, which are rewritten when the range changes.
+	// Indexed by AccelerometerInterrupt, and 0 when the interrupt is disabled.
+	interruptThresholds [2]physic.Force
+	// The configured durations, which are rewritten when the data rate
+	// changes. Indexed by AccelerometerInterrupt.
+	interruptDurations [2]time.Duration
+	// The configured click detection, whose threshold and times are rewritten
+	// like the interrupts'
+	click ClickConfig
+	// Applied in Sense, if set
+	calibration *AccelerometerCalibration
 }
 
+// Reads the raw values of the enabled axes. Disabled axes are always returned
+// as 0; use SenseSample to tell them apart from real readings.
 func (accelerometer *Accelerometer) SenseRaw() (int16, int16, int16, error) {
-	xLow, err := accelerometer.mmr.ReadUint8(ACCELEROMETER_OUT_X_L_A)
+	if accelerometer.axes&ACCELEROMETER_AXES_ALL == 0 {
+		return 0, 0, 0, errors.New("No accelerometer axes enabled")
+	}
+
+	// Read all of the axes at once so that they're from the same sample
+	buffer := make([]byte, 6)
+	err := accelerometer.readBurst(ACCELEROMETER_OUT_X_L_A, buffer)
 	if err != nil {
 		return 0, 0, 0, err
 	}
-	xHigh, err := accelerometer.mmr.ReadUint8(ACCELEROMETER_OUT_X_H_A)
+	sample := accelerometer.decodeSample(buffer)
+
+	return sample[0], sample[1], sample[2], nil
+}
+
+// Reads the enabled axes, corrected with the calibration, if one is set.
+// Disabled axes are always returned as 0.
+func (accelerometer *Accelerometer) Sense() (physic.Force, physic.Force, physic.Force, error) {
+	sample, err := accelerometer.SenseSample()
 	if err != nil {
 		return 0, 0, 0, err
 	}
-	yLow, err := accelerometer.mmr.ReadUint8(ACCELEROMETER_OUT_Y_L_A)
+	xAcceleration, yAcceleration, zAcceleration := sample.Acceleration()
+	if accelerometer.calibration != nil {
+		xAcceleration, yAcceleration, zAcceleration = accelerometer.calibration.Apply(xAcceleration, yAcceleration, zAcceleration)
+		if !accelerometer.axes.Has(ACCELEROMETER_AXIS_X) {
+			xAcceleration = 0
+		}
+		if !accelerometer.axes.Has(ACCELEROMETER_AXIS_Y) {
+			yAcceleration = 0
+		}
+		if !accelerometer.axes.Has(ACCELEROMETER_AXIS_Z) {
+			zAcceleration = 0
+		}
+	}
+
+	return xAcceleration, yAcceleration, zAcceleration, nil
+}
+
+// Reads the raw values, tagged with the range and mode they were taken at. If
+// auto range is enabled, the range is adjusted for the next read, and when it
+// changes, this waits for a sample taken at the new range.
+func (accelerometer *Accelerometer) SenseSample() (AccelerometerSample, error) {
+	range_ := accelerometer.range_
+	mode := accelerometer.mode
+	x, y, z, err := accelerometer.SenseRaw()
 	if err != nil {
-		return 0, 0, 0, err
+		return AccelerometerSample{}, err
 	}
-	yHigh, err := accelerometer.mmr.ReadUint8(ACCELEROMETER_OUT_Y_H_A)
+	sample := AccelerometerSample{X: x, Y: y, Z: z, Range: range_, Mode: mode, Axes: accelerometer.axes}
+	err = accelerometer.updateAutoRange(sample, time.Now())
 	if err != nil {
-		return 0, 0, 0, err
+		return AccelerometerSample{}, err
 	}
-	zLow, err := accelerometer.mmr.ReadUint8(ACCELEROMETER_OUT_Z_L_A)
+	return sample, nil
+}
+
+func (accelerometer *Accelerometer) Status() (AccelerometerStatus, error) {
+	value, err := accelerometer.mmr.ReadUint8(ACCELEROMETER_STATUS_REG_A)
 	if err != nil {
-		return 0, 0, 0, err
+		return AccelerometerStatus{}, err
 	}
-	zHigh, err := accelerometer.mmr.ReadUint8(ACCELEROMETER_OUT_Z_H_A)
+	return AccelerometerStatus{
+		DataAvailable: readBits(uint32(value), 1, 3) == 1,
+		Overrun:       readBits(uint32(value), 1, 7) == 1,
+		AxesAvailable: AccelerometerAxes(readBits(uint32(value), 3, 0)),
+		AxesOverrun:   AccelerometerAxes(readBits(uint32(value), 3, 4)),
+	}, nil
+}
+
+// Waits until a new sample is available and reads it, so the same sample is
+// never returned twice.
+func (accelerometer *Accelerometer) WaitForSample(ctx context.Context) (AccelerometerSample, error) {
+	status, err := accelerometer.waitForDataAvailable(ctx)
 	if err != nil {
-		return 0, 0, 0, err
+		return AccelerometerSample{}, err
+	}
+	sample, err := accelerometer.SenseSample()
+	if err != nil {
+		return AccelerometerSample{}, err
+	}
+	sample.Overrun = status.Overrun
+	return sample, nil
+}
+
+// Polls the status until a new sample is available
+func (accelerometer *Accelerometer) waitForDataAvailable(ctx context.Context) (AccelerometerStatus, error) {
+	frequency := accelerometer.rate.Frequency()
+	if frequency == 0 {
+		return AccelerometerStatus{}, errors.New("Accelerometer is powered down")
+	}
+	// Poll a few times per sample
+	interval := frequency.Period() / 4
+	if interval < time.Millisecond/2 {
+		interval = time.Millisecond / 2
 	}
 
-	xValue := int16(((uint16(xHigh)) << 8) + uint16(xLow))
-	yValue := int16(((uint16(yHigh)) << 8) + uint16(yLow))
-	zValue := int16(((uint16(zHigh)) << 8) + uint16(zLow))
+	for {
+		status, err := accelerometer.Status()
+		if err != nil {
+			return AccelerometerStatus{}, err
+		}
+		if status.DataAvailable {
+			return status, nil
+		}
 
-	return xValue, yValue, zValue, nil
+		select {
+		case <-ctx.Done():
+			return AccelerometerStatus{}, ctx.Err()
+		case <-time.After(interval):
+		}
+	}
 }
 
-func (accelerometer *Accelerometer) Sense() (physic.Force, physic.Force, physic.Force, error) {
-	xValue, yValue, zValue, err := accelerometer.SenseRaw()
+// Throws away the current sample and waits for the next one, so that a sample
+// converted before a settings change is never read with the new settings
+func (accelerometer *Accelerometer) waitForNewSample() error {
+	frequency := accelerometer.rate.Frequency()
+	if frequency == 0 {
+		return errors.New("Accelerometer is powered down")
+	}
+	_, _, _, err := accelerometer.SenseRaw()
 	if err != nil {
-		return 0, 0, 0, err
+		return err
 	}
-	multiplier := getMultiplier(accelerometer.mode, accelerometer.range_)
-	xAcceleration := (physic.Force)(int64(xValue) * multiplier)
-	yAcceleration := (physic.Force)(int64(yValue) * multiplier)
-	zAcceleration := (physic.Force)(int64(zValue) * multiplier)
 
-	return xAcceleration, yAcceleration, zAcceleration, nil
+	// A few sample periods is plenty
+	ctx, cancel := context.WithTimeout(context.Background(), 3*frequency.Period())
+	defer cancel()
+	_, err = accelerometer.waitForDataAvailable(ctx)
+	return err
 }
 
 func (accelerometer *Accelerometer) GetMode() (AccelerometerMode, error) {
@@ -133,6 +316,11 @@ func (accelerometer *Accelerometer) SetMode(mode AccelerometerMode) error {
 	const bits = 1
 	const shift = 3
 
+	err := checkDataRate(accelerometer.rate, mode)
+	if err != nil {
+		return err
+	}
+
 	data := uint8((mode & 0x02) >> 1)
 	power, err := accelerometer.mmr.ReadUint8(ACCELEROMETER_CTRL_REG1_A)
 	if err != nil {
@@ -203,6 +391,390 @@ func (accelerometer *Accelerometer) SetRange(range_ AccelerometerRange) error {
 
 	accelerometer.range_ = range_
 
+	// The thresholds are scaled by the range
+	return accelerometer.rescaleThresholds()
+}
+
+func (accelerometer *Accelerometer) GetDataRate() (AccelerometerDataRate, error) {
+	value, err := accelerometer.mmr.ReadUint8(ACCELEROMETER_CTRL_REG1_A)
+	if err != nil {
+		return ACCELEROMETER_RATE_100, err
+	}
+	rate := AccelerometerDataRate(readBits(uint32(value), 4, 4)) + ACCELEROMETER_RATE_POWER_DOWN
+	lowPower := readBits(uint32(value), 1, 3) == 1
+	if rate > ACCELEROMETER_RATE_1344 {
+		return ACCELEROMETER_RATE_100, errors.New("Unknown accelerometer data rate")
+	}
+	// 1.344 kHz and 5.376 kHz share the same register value
+	if rate == ACCELEROMETER_RATE_1344 && lowPower {
+		rate = ACCELEROMETER_RATE_5376_LOW_POWER
+	}
+	return rate, nil
+}
+
+// Sets the output data rate. Some rates are only available in low power mode,
+// so set the mode first if you want to use one of them. Configured durations
+// are counted in samples, so they're rewritten for the new rate, and the rate
+// is left unchanged if they don't fit.
+func (accelerometer *Accelerometer) SetDataRate(rate AccelerometerDataRate) error {
+	const bits = 4
+	const shift = 4
+
+	rate = rate.orDefault()
+	err := checkDataRate(rate, accelerometer.mode)
+	if err != nil {
+		return err
+	}
+	// The durations are counted in samples
+	durations, err := accelerometer.getDurationWrites(rate)
+	if err != nil {
+		return err
+	}
+
+	data := rate.registerValue()
+	current, err := accelerometer.mmr.ReadUint8(ACCELEROMETER_CTRL_REG1_A)
+	if err != nil {
+		return err
+	}
+
+	mask := uint8((1 << bits) - 1)
+	data &= mask
+	mask <<= shift
+	current &= (^mask)
+	current |= data << shift
+	err = accelerometer.mmr.WriteUint8(ACCELEROMETER_CTRL_REG1_A, current)
+	if err != nil {
+		return err
+	}
+	time.Sleep(time.Millisecond * 20)
+
+	accelerometer.rate = rate
+
+	for _, write := range durations {
+		err = accelerometer.mmr.WriteUint8(write.register, write.value)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (accelerometer *Accelerometer) GetEnabledAxes() (AccelerometerAxes, error) {
+	value, err := accelerometer.mmr.ReadUint8(ACCELEROMETER_CTRL_REG1_A)
+	if err != nil {
+		return ACCELEROMETER_AXES_ALL, err
+	}
+	return AccelerometerAxes(readBits(uint32(value), 3, 0)), nil
+}
+
+// Enables the given axes and disables the rest. Disabling axes that you don't
+// need saves power and bus time.
+func (accelerometer *Accelerometer) SetEnabledAxes(axes AccelerometerAxes) error {
+	const bits = 3
+	const shift = 0
+
+	data := uint8(axes)
+	current, err := accelerometer.mmr.ReadUint8(ACCELEROMETER_CTRL_REG1_A)
+	if err != nil {
+		return err
+	}
+
+	mask := uint8((1 << bits) - 1)
+	data &= mask
+	mask <<= shift
+	current &= (^mask)
+	current |= data << shift
+	err = accelerometer.mmr.WriteUint8(ACCELEROMETER_CTRL_REG1_A, current)
+	if err != nil {
+		return err
+	}
+	time.Sleep(time.Millisecond * 20)
+
+	accelerometer.axes = axes & ACCELEROMETER_AXES_ALL
+
+	return nil
+}
+
+// Configures the high-pass filter. This can be used to remove gravity in
+// hardware.
+func (accelerometer *Accelerometer) SetHighPassFilter(filter AccelerometerHighPassFilter) error {
+	err := accelerometer.mmr.WriteUint8(ACCELEROMETER_CTRL_REG2_A, filter.registerValue())
+	if err != nil {
+		return err
+	}
+	time.Sleep(time.Millisecond * 20)
+
+	return nil
+}
+
+func (accelerometer *Accelerometer) GetHighPassFilter() (AccelerometerHighPassFilter, error) {
+	value, err := accelerometer.mmr.ReadUint8(ACCELEROMETER_CTRL_REG2_A)
+	if err != nil {
+		return AccelerometerHighPassFilter{}, err
+	}
+	return AccelerometerHighPassFilter{
+		Mode:       AccelerometerHighPassMode(readBits(uint32(value), 2, 6)),
+		Cutoff:     AccelerometerHighPassCutoff(readBits(uint32(value), 2, 4)),
+		Output:     readBits(uint32(value), 1, 3) == 1,
+		Click:      readBits(uint32(value), 1, 2) == 1,
+		Interrupt2: readBits(uint32(value), 1, 1) == 1,
+		Interrupt1: readBits(uint32(value), 1, 0) == 1,
+	}, nil
+}
+
+// Sets the reference value that is subtracted from the output in
+// ACCELEROMETER_HIGH_PASS_MODE_REFERENCE.
+func (accelerometer *Accelerometer) SetHighPassReference(reference uint8) error {
+	return accelerometer.mmr.WriteUint8(ACCELEROMETER_REFERENCE_A, reference)
+}
+
+func (accelerometer *Accelerometer) GetHighPassReference() (uint8, error) {
+	return accelerometer.mmr.ReadUint8(ACCELEROMETER_REFERENCE_A)
+}
+
+// Resets the high-pass filter to the current acceleration. This only has an
+// effect in ACCELEROMETER_HIGH_PASS_MODE_NORMAL_RESET, where reading the
+// reference register resets the filter.
+func (accelerometer *Accelerometer) ResetHighPassFilter() error {
+	_, err := accelerometer.mmr.ReadUint8(ACCELEROMETER_REFERENCE_A)
+	return err
+}
+
+// Configures the FIFO. Any samples already in the FIFO are discarded.
+func (accelerometer *Accelerometer) SetFifo(fifo AccelerometerFifo) error {
+	if fifo.Watermark >= ACCELEROMETER_FIFO_SIZE {
+		return errors.New("FIFO watermark must be less than 32")
+	}
+
+	const bits = 1
+	const shift = 6
+
+	data := uint8(0)
+	if fifo.Mode != ACCELEROMETER_FIFO_MODE_BYPASS {
+		data = 1
+	}
+	current, err := accelerometer.mmr.ReadUint8(ACCELEROMETER_CTRL_REG5_A)
+	if err != nil {
+		return err
+	}
+
+	mask := uint8((1 << bits) - 1)
+	data &= mask
+	mask <<= shift
+	current &= (^mask)
+	current |= data << shift
+	err = accelerometer.mmr.WriteUint8(ACCELEROMETER_CTRL_REG5_A, current)
+	if err != nil {
+		return err
+	}
+
+	// The FIFO needs to go through bypass mode to be cleared
+	err = accelerometer.mmr.WriteUint8(ACCELEROMETER_FIFO_CTRL_REG_A, 0)
+	if err != nil {
+		return err
+	}
+	if fifo.Mode != ACCELEROMETER_FIFO_MODE_BYPASS {
+		err = accelerometer.mmr.WriteUint8(ACCELEROMETER_FIFO_CTRL_REG_A, fifo.registerValue())
+		if err != nil {
+			return err
+		}
+	}
+	time.Sleep(time.Millisecond * 20)
+
+	return nil
+}
+
+func (accelerometer *Accelerometer) GetFifo() (AccelerometerFifo, error) {
+	value, err := accelerometer.mmr.ReadUint8(ACCELEROMETER_FIFO_CTRL_REG_A)
+	if err != nil {
+		return AccelerometerFifo{}, err
+	}
+	return AccelerometerFifo{
+		Mode:              AccelerometerFifoMode(readBits(uint32(value), 2, 6)),
+		TriggerInterrupt2: readBits(uint32(value), 1, 5) == 1,
+		Watermark:         uint8(readBits(uint32(value), 5, 0)),
+	}, nil
+}
+
+func (accelerometer *Accelerometer) GetFifoStatus() (AccelerometerFifoStatus, error) {
+	value, err := accelerometer.mmr.ReadUint8(ACCELEROMETER_FIFO_SRC_REG_A)
+	if err != nil {
+		return AccelerometerFifoStatus{}, err
+	}
+	status := AccelerometerFifoStatus{
+		Watermark: readBits(uint32(value), 1, 7) == 1,
+		Overrun:   readBits(uint32(value), 1, 6) == 1,
+		Empty:     readBits(uint32(value), 1, 5) == 1,
+		Samples:   int(readBits(uint32(value), 5, 0)),
+	}
+	// The sample count is only 5 bits, so it can't represent a full FIFO
+	if status.Empty {
+		status.Samples = 0
+	} else if status.Overrun {
+		status.Samples = ACCELEROMETER_FIFO_SIZE
+	}
+	return status, nil
+}
+
+// Drains up to len(samples) samples from the FIFO in a single burst and
+// returns the number of samples read. Disabled axes are always returned as 0
+// and left out of each sample's Axes.
+func (accelerometer *Accelerometer) ReadFifo(samples []AccelerometerSample) (int, error) {
+	status, err := accelerometer.GetFifoStatus()
+	if err != nil {
+		return 0, err
+	}
+	count := status.Samples
+	if count > len(samples) {
+		count = len(samples)
+	}
+	if count == 0 {
+		return 0, nil
+	}
+
+	// When the FIFO is enabled, the register address rolls back from
+	// OUT_Z_H_A to OUT_X_L_A, so every sample can be read in one transaction
+	buffer := make([]byte, 6*count)
+	err = accelerometer.readBurst(ACCELEROMETER_OUT_X_L_A, buffer)
+	if err != nil {
+		return 0, err
+	}
+	for i := 0; i < count; i++ {
+		values := accelerometer.decodeSample(buffer[6*i : 6*i+6])
+		samples[i] = AccelerometerSample{
+			X:     values[0],
+			Y:     values[1],
+			Z:     values[2],
+			Range: accelerometer.range_,
+			Mode:  accelerometer.mode,
+			Axes:  accelerometer.axes,
+		}
+	}
+	return count, nil
+}
+
+// Reads consecutive registers in one transaction. The accelerometer only
+// increments the register address if the MSB of the address is set.
+func (accelerometer *Accelerometer) readBurst(register uint8, buffer []byte) error {
+	return accelerometer.mmr.Conn.Tx([]byte{register | 0x80}, buffer)
+}
+
+// Decodes the 6 output bytes in X Y Z order
+func (accelerometer *Accelerometer) decodeSample(buffer []byte) [3]int16 {
+	var order binary.ByteOrder = binary.LittleEndian
+	if accelerometer.bigEndian {
+		order = binary.BigEndian
+	}
+	var sample [3]int16
+	for i, axis := range [...]AccelerometerAxes{ACCELEROMETER_AXIS_X, ACCELEROMETER_AXIS_Y, ACCELEROMETER_AXIS_Z} {
+		if accelerometer.axes.Has(axis) {
+			sample[i] = int16(order.Uint16(buffer[2*i:]))
+		}
+	}
+	return sample
+}
+
+// Reads the byte order of the output registers. Call this if something else
+// sharing the device might have changed it.
+func (accelerometer *Accelerometer) GetByteOrder() (AccelerometerByteOrder, error) {
+	value, err := accelerometer.mmr.ReadUint8(ACCELEROMETER_CTRL_REG4_A)
+	if err != nil {
+		return ACCELEROMETER_BYTE_ORDER_LITTLE_ENDIAN, err
+	}
+	accelerometer.bigEndian = readBits(uint32(value), 1, 6) == 1
+	if accelerometer.bigEndian {
+		return ACCELEROMETER_BYTE_ORDER_BIG_ENDIAN, nil
+	}
+	return ACCELEROMETER_BYTE_ORDER_LITTLE_ENDIAN, nil
+}
+
+func (accelerometer *Accelerometer) SetByteOrder(order AccelerometerByteOrder) error {
+	var data uint8
+	switch order {
+	case ACCELEROMETER_BYTE_ORDER_LITTLE_ENDIAN:
+		data = 0
+	case ACCELEROMETER_BYTE_ORDER_BIG_ENDIAN:
+		data = 1
+	default:
+		return errors.New("Byte order must be little or big endian")
+	}
+	err := writeBits(&accelerometer.mmr, ACCELEROMETER_CTRL_REG4_A, 1, 6, data)
+	if err != nil {
+		return err
+	}
+	time.Sleep(time.Millisecond * 20)
+
+	accelerometer.bigEndian = data == 1
+
+	return nil
+}
+
+// Reloads the factory trimming parameters. This doesn't change any of the
+// user settings.
+func (accelerometer *Accelerometer) Reboot() error {
+	// BOOT
+	err := writeBits(&accelerometer.mmr, ACCELEROMETER_CTRL_REG5_A, 1, 7, 1)
+	if err != nil {
+		return err
+	}
+	time.Sleep(time.Millisecond * 20)
+
+	return nil
+}
+
+// Reboots and then restores the default values of every setting, including
+// the FIFO, interrupts, clicks and high-pass filter. This leaves the
+// accelerometer powered down.
+func (accelerometer *Accelerometer) Reset() error {
+	err := accelerometer.Reboot()
+	if err != nil {
+		return err
+	}
+
+	// Disable interrupts and the FIFO first so nothing fires while resetting
+	defaults := [...]struct {
+		register uint8
+		value    uint8
+	}{
+		{ACCELEROMETER_CTRL_REG3_A, 0},
+		{ACCELEROMETER_CTRL_REG6_A, 0},
+		{ACCELEROMETER_CTRL_REG5_A, 0},
+		{ACCELEROMETER_FIFO_CTRL_REG_A, 0},
+		{ACCELEROMETER_INT1_CFG_A, 0},
+		{ACCELEROMETER_INT1_THS_A, 0},
+		{ACCELEROMETER_INT1_DURATION_A, 0},
+		{ACCELEROMETER_INT2_CFG_A, 0},
+		{ACCELEROMETER_INT2_THS_A, 0},
+		{ACCELEROMETER_INT2_DURATION_A, 0},
+		{ACCELEROMETER_CLICK_CFG_A, 0},
+		{ACCELEROMETER_CLICK_THS_A, 0},
+		{ACCELEROMETER_TIME_LIMIT_A, 0},
+		{ACCELEROMETER_TIME_LATENCY_A, 0},
+		{ACCELEROMETER_TIME_WINDOW_A, 0},
+		{ACCELEROMETER_REFERENCE_A, 0},
+		{ACCELEROMETER_CTRL_REG2_A, 0},
+		{ACCELEROMETER_CTRL_REG4_A, 0},
+		{ACCELEROMETER_CTRL_REG1_A, 0x07},
+	}
+	for _, register := range defaults {
+		err = accelerometer.mmr.WriteUint8(register.register, register.value)
+		if err != nil {
+			return err
+		}
+	}
+	time.Sleep(time.Millisecond * 20)
+
+	accelerometer.range_ = ACCELEROMETER_RANGE_2G
+	accelerometer.mode = ACCELEROMETER_MODE_NORMAL
+	accelerometer.rate = ACCELEROMETER_RATE_POWER_DOWN
+	accelerometer.axes = ACCELEROMETER_AXES_ALL
+	accelerometer.bigEndian = false
+	accelerometer.clickEnabled = false
+	accelerometer.interruptThresholds = [2]physic.Force{}
+	accelerometer.interruptDurations = [2]time.Duration{}
+	accelerometer.click = ClickConfig{}
+
 	return nil
 }
 
@@ -210,6 +782,49 @@ func (accelerometer *Accelerometer) String() string {
 	return "LSM303 accelerometer"
 }
 
+// The decoded contents of STATUS_REG_A
+type AccelerometerStatus struct {
+	// A new sample is available for all axes
+	DataAvailable bool
+	// A sample was overwritten before it was read
+	Overrun       bool
+	AxesAvailable AccelerometerAxes
+	AxesOverrun   AccelerometerAxes
+}
+
+type AccelerometerSample struct {
+	X, Y, Z int16
+	// At least one sample was missed since the last read
+	Overrun bool
+	// The settings that the sample was taken with
+	Range AccelerometerRange
+	Mode  AccelerometerMode
+	// The axes that were enabled. Disabled axes are always 0.
+	Axes AccelerometerAxes
+}
+
+// Converts the sample using the range and mode it was taken with
+func (sample AccelerometerSample) Acceleration() (physic.Force, physic.Force, physic.Force) {
+	multiplier := getMultiplier(sample.Mode, sample.Range)
+	xAcceleration := (physic.Force)(int64(sample.X) * multiplier)
+	yAcceleration := (physic.Force)(int64(sample.Y) * multiplier)
+	zAcceleration := (physic.Force)(int64(sample.Z) * multiplier)
+	return xAcceleration, yAcceleration, zAcceleration
+}
+
+type AccelerometerByteOrder int
+
+const (
+	// Use whatever the device is currently set to
+	ACCELEROMETER_BYTE_ORDER_DEVICE AccelerometerByteOrder = iota
+	ACCELEROMETER_BYTE_ORDER_LITTLE_ENDIAN
+	ACCELEROMETER_BYTE_ORDER_BIG_ENDIAN
+)
+
+func (order AccelerometerByteOrder) String() string {
+	return [...]string{"device", "little endian", "big endian"}[order]
+}
+
 type AccelerometerMode int
 
 const (
@@ -235,11 +850,241 @@ func (range_ AccelerometerRange) String() string {
 	return [...]string{"2G", "4G", "8G", "16G"}[range_]
 }
 
+type AccelerometerDataRate int
+
+const (
+	// The zero value, so that options that don't set a rate get 100 Hz
+	ACCELEROMETER_RATE_DEFAULT AccelerometerDataRate = iota
+	ACCELEROMETER_RATE_POWER_DOWN
+	ACCELEROMETER_RATE_1
+	ACCELEROMETER_RATE_10
+	ACCELEROMETER_RATE_25
+	ACCELEROMETER_RATE_50
+	ACCELEROMETER_RATE_100
+	ACCELEROMETER_RATE_200
+	ACCELEROMETER_RATE_400
+	ACCELEROMETER_RATE_1620_LOW_POWER
+	ACCELEROMETER_RATE_1344
+	ACCELEROMETER_RATE_5376_LOW_POWER
+)
+
+func (rate AccelerometerDataRate) String() string {
+	return [...]string{"default", "power down", "1", "10", "25", "50", "100", "200", "400", "1620", "1344", "5376"}[rate]
+}
+
+func (rate AccelerometerDataRate) Frequency() physic.Frequency {
+	return [...]physic.Frequency{
+		100 * physic.Hertz,
+		0,
+		physic.Hertz,
+		10 * physic.Hertz,
+		25 * physic.Hertz,
+		50 * physic.Hertz,
+		100 * physic.Hertz,
+		200 * physic.Hertz,
+		400 * physic.Hertz,
+		1620 * physic.Hertz,
+		1344 * physic.Hertz,
+		5376 * physic.Hertz,
+	}[rate]
+}
+
+// A bit mask of accelerometer axes
+type AccelerometerAxes uint8
+
+const (
+	ACCELEROMETER_AXIS_X   AccelerometerAxes = 1 << 0
+	ACCELEROMETER_AXIS_Y   AccelerometerAxes = 1 << 1
+	ACCELEROMETER_AXIS_Z   AccelerometerAxes = 1 << 2
+	ACCELEROMETER_AXES_ALL                   = ACCELEROMETER_AXIS_X | ACCELEROMETER_AXIS_Y | ACCELEROMETER_AXIS_Z
+)
+
+func (axes AccelerometerAxes) orDefault() AccelerometerAxes {
+	axes &= ACCELEROMETER_AXES_ALL
+	if axes == 0 {
+		return ACCELEROMETER_AXES_ALL
+	}
+	return axes
+}
+
+func (axes AccelerometerAxes) Has(axis AccelerometerAxes) bool {
+	return axes&axis == axis
+}
+
+func (axes AccelerometerAxes) String() string {
+	result := ""
+	for i, name := range [...]string{"X", "Y", "Z"} {
+		if axes.Has(1 << i) {
+			result += name
+		}
+	}
+	if result == "" {
+		return "none"
+	}
+	return result
+}
+
+// High-pass filter settings
+type AccelerometerHighPassFilter struct {
+	Mode   AccelerometerHighPassMode
+	Cutoff AccelerometerHighPassCutoff
+	// Send filtered data to the output registers and FIFO
+	Output bool
+	// Send filtered data to the click detector
+	Click bool
+	// Send filtered data to the interrupt generators
+	Interrupt1 bool
+	Interrupt2 bool
+}
+
+func (filter AccelerometerHighPassFilter) registerValue() uint8 {
+	value := (uint8(filter.Mode&0x03) << 6) | (uint8(filter.Cutoff&0x03) << 4)
+	if filter.Output {
+		value |= 1 << 3
+	}
+	if filter.Click {
+		value |= 1 << 2
+	}
+	if filter.Interrupt2 {
+		value |= 1 << 1
+	}
+	if filter.Interrupt1 {
+		value |= 1 << 0
+	}
+	return value
+}
+
+type AccelerometerHighPassMode int
+
+const (
+	// Normal mode, reset by reading the reference register
+	ACCELEROMETER_HIGH_PASS_MODE_NORMAL_RESET AccelerometerHighPassMode = iota
+	// The reference register is subtracted from the output
+	ACCELEROMETER_HIGH_PASS_MODE_REFERENCE
+	ACCELEROMETER_HIGH_PASS_MODE_NORMAL
+	// The filter is reset when an interrupt event occurs
+	ACCELEROMETER_HIGH_PASS_MODE_AUTORESET
+)
+
+func (mode AccelerometerHighPassMode) String() string {
+	return [...]string{"normal with reset", "reference", "normal", "autoreset on interrupt"}[mode]
+}
+
+// The actual cutoff frequency depends on the data rate, see the data sheet.
+// Higher values have a lower cutoff frequency.
+type AccelerometerHighPassCutoff int
+
+const (
+	ACCELEROMETER_HIGH_PASS_CUTOFF_0 AccelerometerHighPassCutoff = iota
+	ACCELEROMETER_HIGH_PASS_CUTOFF_1
+	ACCELEROMETER_HIGH_PASS_CUTOFF_2
+	ACCELEROMETER_HIGH_PASS_CUTOFF_3
+)
+
+func (cutoff AccelerometerHighPassCutoff) String() string {
+	return [...]string{"0", "1", "2", "3"}[cutoff]
+}
+
+// The number of samples that the FIFO can hold
+const ACCELEROMETER_FIFO_SIZE = 32
+
+// FIFO settings
+type AccelerometerFifo struct {
+	Mode AccelerometerFifoMode
+	// Stream-to-FIFO mode is triggered by interrupt 1 unless this is set
+	TriggerInterrupt2 bool
+	// The watermark flag is set when the FIFO holds more than this many
+	// samples, 0-31
+	Watermark uint8
+}
+
+func (fifo AccelerometerFifo) registerValue() uint8 {
+	value := (uint8(fifo.Mode&0x03) << 6) | (fifo.Watermark & 0x1F)
+	if fifo.TriggerInterrupt2 {
+		value |= 1 << 5
+	}
+	return value
+}
+
+type AccelerometerFifoMode int
+
+const (
+	// The FIFO is not used
+	ACCELEROMETER_FIFO_MODE_BYPASS AccelerometerFifoMode = iota
+	// Collects samples until the FIFO is full, then stops
+	ACCELEROMETER_FIFO_MODE_FIFO
+	// Collects samples, overwriting the oldest ones when the FIFO is full
+	ACCELEROMETER_FIFO_MODE_STREAM
+	// Stream mode until the trigger interrupt fires, then FIFO mode
+	ACCELEROMETER_FIFO_MODE_STREAM_TO_FIFO
+)
+
+func (mode AccelerometerFifoMode) String() string {
+	return [...]string{"bypass", "FIFO", "stream", "stream to FIFO"}[mode]
+}
+
+type AccelerometerFifoStatus struct {
+	// The number of unread samples
+	Samples int
+	// The FIFO holds more samples than the watermark
+	Watermark bool
+	// The FIFO is full and at least one sample has been overwritten
+	Overrun bool
+	Empty   bool
+}
+
+// The 1.344 kHz and 5.376 kHz rates share the same register value and are
+// distinguished by the low power bit
+func (rate AccelerometerDataRate) registerValue() uint8 {
+	rate = rate.orDefault()
+	if rate == ACCELEROMETER_RATE_5376_LOW_POWER {
+		rate = ACCELEROMETER_RATE_1344
+	}
+	return uint8(rate - ACCELEROMETER_RATE_POWER_DOWN)
+}
+
+func (rate AccelerometerDataRate) orDefault() AccelerometerDataRate {
+	if rate == ACCELEROMETER_RATE_DEFAULT {
+		return ACCELEROMETER_RATE_100
+	}
+	return rate
+}
+
+// Checks that the data rate is available in the mode
+func checkDataRate(rate AccelerometerDataRate, mode AccelerometerMode) error {
+	if rate < ACCELEROMETER_RATE_DEFAULT || rate > ACCELEROMETER_RATE_5376_LOW_POWER {
+		return errors.New("Unknown accelerometer data rate")
+	}
+	lowPowerOnly := rate == ACCELEROMETER_RATE_1620_LOW_POWER || rate == ACCELEROMETER_RATE_5376_LOW_POWER
+	if lowPowerOnly && mode != ACCELEROMETER_MODE_LOW_POWER {
+		return errors.New("Accelerometer data rate " + rate.String() + " is only available in low power mode")
+	}
+	if rate == ACCELEROMETER_RATE_1344 && mode == ACCELEROMETER_MODE_LOW_POWER {
+		return errors.New("Accelerometer data rate 1344 is not available in low power mode")
+	}
+	return nil
+}
+
 func readBits(value uint32, bits uint32, shift uint8) uint32 {
 	value >>= shift
 	return value & ((1 << bits) - 1)
 }
 
+// Replaces some bits in a register, leaving the rest unchanged
+func writeBits(device *mmr.Dev8, register uint8, bits uint8, shift uint8, data uint8) error {
+	current, err := device.ReadUint8(register)
+	if err != nil {
+		return err
+	}
+
+	mask := uint8((1 << bits) - 1)
+	data &= mask
+	mask <<= shift
+	current &= (^mask)
+	current |= data << shift
+	return device.WriteUint8(register, current)
+}
+
 // Gets the multiplier for the accelerometer mode and range
 func getMultiplier(mode AccelerometerMode, range_ AccelerometerRange) int64 {
 	// The constants in here needed to be rounded because some of then aren't
@@ -290,12 +1135,51 @@ func getMultiplier(mode AccelerometerMode, range_ AccelerometerRange) int64 {
 type MagnetometerOpts struct {
 	Gain MagnetometerGain
 	Rate MagnetometerRate
+	Mode MagnetometerMode
+	// Step the gain up and down to keep readings in range
+	AutoGain bool
+	// Restore the default register values before applying these options
+	Reset bool
 }
 
 // DefaultMagnetometerOpts is the recommended default options.
 var DefaultMagnetometerOpts = MagnetometerOpts{
 	Gain: MAGNETOMETER_GAIN_4_0,
 	Rate: MAGNETOMETER_RATE_30,
+	Mode: MAGNETOMETER_MODE_CONTINUOUS,
+}
+
+type MagnetometerMode int
+
+const (
+	MAGNETOMETER_MODE_CONTINUOUS MagnetometerMode = iota
+	// Converts one sample and then goes to sleep
+	MAGNETOMETER_MODE_SINGLE
+	MAGNETOMETER_MODE_SLEEP
+)
+
+func (mode MagnetometerMode) String() string {
+	return [...]string{"continuous", "single", "sleep"}[mode]
+}
+
+func (mode MagnetometerMode) registerValue() uint8 {
+	if mode == MAGNETOMETER_MODE_SLEEP {
+		// 0b10 is also sleep, but 0b11 is the default
+		return 0x03
+	}
+	return uint8(mode)
+}
+
+// How often to check whether a conversion has finished
+const magnetometerPollInterval = 2 * time.Millisecond
+
+// The decoded contents of SR_REG_M
+type MagnetometerStatus struct {
+	// A new sample is available
+	DataReady bool
+	// Some, but not all, of the output registers have been read, so the
+	// output registers are locked until the rest are read
+	Lock bool
 }
 
 type MagnetometerGain int
@@ -314,6 +1198,34 @@ func (mode MagnetometerGain) String() string {
 	return [...]string{"1.3", "1.9", "2.5", "4.0", "4.7", "5.6", "8.1"}[mode]
 }
 
+// The register values start at 1, 0 is not a valid gain
+func (gain MagnetometerGain) registerValue() uint8 {
+	return uint8(gain) + 1
+}
+
+// Gets the LSB per gauss from the data sheet for the X and Y axes, and for
+// the Z axis
+func getMagnetometerLsbPerGauss(gain MagnetometerGain) (int64, int64) {
+	switch gain {
+	case MAGNETOMETER_GAIN_1_3:
+		return 1100, 980
+	case MAGNETOMETER_GAIN_1_9:
+		return 855, 760
+	case MAGNETOMETER_GAIN_2_5:
+		return 670, 600
+	case MAGNETOMETER_GAIN_4_0:
+		return 450, 400
+	case MAGNETOMETER_GAIN_4_7:
+		return 400, 355
+	case MAGNETOMETER_GAIN_5_6:
+		return 330, 295
+	case MAGNETOMETER_GAIN_8_1:
+		return 230, 205
+	}
+	log.Fatalf("Unknown gain %v in getMagnetometerLsbPerGauss", gain)
+	return 0, 0
+}
+
 type MagnetometerRate int
 
 const (
@@ -331,6 +1243,19 @@ func (range_ MagnetometerRate) String() string {
 	return [...]string{"0.75", "1.55", "3.05", "7.55", "15", "30", "75", "220"}[range_]
 }
 
+func (rate MagnetometerRate) Frequency() physic.Frequency {
+	return [...]physic.Frequency{
+		750 * physic.MilliHertz,
+		1500 * physic.MilliHertz,
+		3 * physic.Hertz,
+		7500 * physic.MilliHertz,
+		15 * physic.Hertz,
+		30 * physic.Hertz,
+		75 * physic.Hertz,
+		220 * physic.Hertz,
+	}[rate]
+}
+
 // New magnetometer opens a handle to an LSM303 magnetometer sensor.
 func NewMagnetometer(bus i2c.Bus, opts *MagnetometerOpts) (*Magnetometer, error) {
 	device := &Magnetometer{
@@ -344,8 +1269,18 @@ func NewMagnetometer(bus i2c.Bus, opts *MagnetometerOpts) (*Magnetometer, error)
 		rate: opts.Rate,
 	}
 
+	if opts.Reset {
+		err := device.Reset()
+		if err != nil {
+			return nil, err
+		}
+		// Reset clears the cached settings
+		device.gain = opts.Gain
+		device.rate = opts.Rate
+	}
+
 	// Enable the magnetometer
-	err := device.mmr.WriteUint8(MAGNETOMETER_MR_REG_M, 0x00)
+	err := device.mmr.WriteUint8(MAGNETOMETER_MR_REG_M, opts.Mode.registerValue())
 	if err != nil {
 		return nil, err
 	}
@@ -361,6 +1296,7 @@ func NewMagnetometer(bus i2c.Bus, opts *MagnetometerOpts) (*Magnetometer, error)
 
 	device.SetGain(opts.Gain)
 	device.SetRate(opts.Rate)
+	device.autoGain = opts.AutoGain
 
 	return device, nil
 }
@@ -370,49 +1306,224 @@ type Magnetometer struct {
 	mmr  mmr.Dev8
 	rate MagnetometerRate
 	gain MagnetometerGain
+	// Adjust the gain in Sense
+	autoGain bool
+	// Applied in Sense, if set
+	calibration *MagnetometerCalibration
+	// Added in SenseTemperature
+	temperatureOffset physic.Temperature
 }
 
+// Reads the raw values. If any axis is saturated, the values are still
+// returned along with a *SaturationError, so that the other axes can be used.
 func (magnetometer *Magnetometer) SenseRaw() (int16, int16, int16, error) {
-	xLow, err := magnetometer.mmr.ReadUint8(MAGNETOMETER_OUT_X_L_M)
+	// The magnetometer always increments the register address, and the
+	// registers are ordered X Z Y, high byte first
+	buffer := make([]byte, 6)
+	err := magnetometer.mmr.Conn.Tx([]byte{MAGNETOMETER_OUT_X_H_M}, buffer)
 	if err != nil {
 		return 0, 0, 0, err
 	}
-	xHigh, err := magnetometer.mmr.ReadUint8(MAGNETOMETER_OUT_X_H_M)
-	if err != nil {
+
+	xValue := int16(((uint16(buffer[0])) << 8) + uint16(buffer[1]))
+	zValue := int16(((uint16(buffer[2])) << 8) + uint16(buffer[3]))
+	yValue := int16(((uint16(buffer[4])) << 8) + uint16(buffer[5]))
+
+	return xValue, yValue, zValue, checkSaturation(xValue, yValue, zValue)
+}
+
+// Restores the default register values from the data sheet. The magnetometer
+// doesn't have a reboot bit, so this is the closest thing to a power cycle.
+// This leaves the magnetometer asleep.
+func (magnetometer *Magnetometer) Reset() error {
+	defaults := [...]struct {
+		register uint8
+		value    uint8
+	}{
+		// Sleep first so that nothing is converted with partial settings
+		{MAGNETOMETER_MR_REG_M, 0x03},
+		// 15 Hz, temperature sensor disabled
+		{MAGNETOMETER_CRA_REG_M, 0x10},
+		// +-1.3 gauss
+		{MAGNETOMETER_CRB_REG_M, 0x20},
+	}
+	for _, register := range defaults {
+		err := magnetometer.mmr.WriteUint8(register.register, register.value)
+		if err != nil {
+			return err
+		}
+	}
+	time.Sleep(time.Millisecond * 20)
+
+	magnetometer.rate = MAGNETOMETER_RATE_15
+	magnetometer.gain = MAGNETOMETER_GAIN_1_3
+
+	return nil
+}
+
+// Reads the magnetic field, converted using the current gain and corrected
+// with the calibration, if one is set. Saturated axes are reported the same
+// way as SenseRaw. If auto gain is enabled, the gain is adjusted for the next
+// read, and when it changes, this waits for a sample taken at the new gain.
+func (magnetometer *Magnetometer) Sense() (MagneticFluxDensity, MagneticFluxDensity, MagneticFluxDensity, error) {
+	xValue, yValue, zValue, err := magnetometer.SenseRaw()
+	_, saturated := err.(*SaturationError)
+	if err != nil && !saturated {
 		return 0, 0, 0, err
 	}
-	yLow, err := magnetometer.mmr.ReadUint8(MAGNETOMETER_OUT_Y_L_M)
+	gain := magnetometer.gain
+	x, y, z := convertMagnetometer(xValue, yValue, zValue, gain)
+
+	if magnetometer.autoGain {
+		next := nextMagnetometerGain(gain, saturated, x, y, z)
+		if next != gain {
+			gainErr := magnetometer.SetGain(next)
+			if gainErr != nil {
+				return 0, 0, 0, gainErr
+			}
+			// The output registers still hold a sample from the old gain
+			gainErr = magnetometer.waitForNewSample()
+			if gainErr != nil {
+				return 0, 0, 0, gainErr
+			}
+		}
+	}
+	if magnetometer.calibration != nil {
+		x, y, z = magnetometer.calibration.Apply(x, y, z)
+	}
+	return x, y, z, err
+}
+
+func convertMagnetometer(xValue, yValue, zValue int16, gain MagnetometerGain) (MagneticFluxDensity, MagneticFluxDensity, MagneticFluxDensity) {
+	xyLsb, zLsb := getMagnetometerLsbPerGauss(gain)
+	x := MagneticFluxDensity(int64(xValue) * int64(Gauss) / xyLsb)
+	y := MagneticFluxDensity(int64(yValue) * int64(Gauss) / xyLsb)
+	z := MagneticFluxDensity(int64(zValue) * int64(Gauss) / zLsb)
+	return x, y, z
+}
+
+func (magnetometer *Magnetometer) GetMode() (MagnetometerMode, error) {
+	value, err := magnetometer.mmr.ReadUint8(MAGNETOMETER_MR_REG_M)
 	if err != nil {
-		return 0, 0, 0, err
+		return MAGNETOMETER_MODE_CONTINUOUS, err
+	}
+	// Both 0b10 and 0b11 are sleep
+	mode := readBits(uint32(value), 2, 0)
+	if mode > uint32(MAGNETOMETER_MODE_SLEEP) {
+		mode = uint32(MAGNETOMETER_MODE_SLEEP)
 	}
-	yHigh, err := magnetometer.mmr.ReadUint8(MAGNETOMETER_OUT_Y_H_M)
+	return MagnetometerMode(mode), nil
+}
+
+func (magnetometer *Magnetometer) SetMode(mode MagnetometerMode) error {
+	err := magnetometer.mmr.WriteUint8(MAGNETOMETER_MR_REG_M, mode.registerValue())
 	if err != nil {
-		return 0, 0, 0, err
+		return err
 	}
-	zLow, err := magnetometer.mmr.ReadUint8(MAGNETOMETER_OUT_Z_L_M)
+	time.Sleep(time.Millisecond * 20)
+
+	return nil
+}
+
+// Stops conversions to save power. Use SetMode or SenseOnce to start again.
+func (magnetometer *Magnetometer) Sleep() error {
+	return magnetometer.SetMode(MAGNETOMETER_MODE_SLEEP)
+}
+
+// Triggers a single conversion, waits for it to finish and reads it. The
+// magnetometer goes back to sleep afterward.
+func (magnetometer *Magnetometer) SenseOnce(ctx context.Context) (int16, int16, int16, error) {
+	// Throw away any old sample so that DRDY only signals the new one
+	status, err := magnetometer.Status()
 	if err != nil {
 		return 0, 0, 0, err
 	}
-	zHigh, err := magnetometer.mmr.ReadUint8(MAGNETOMETER_OUT_Z_H_M)
+	if status.DataReady {
+		_, _, _, err = magnetometer.SenseRaw()
+		if _, saturated := err.(*SaturationError); err != nil && !saturated {
+			return 0, 0, 0, err
+		}
+	}
+
+	err = magnetometer.mmr.WriteUint8(MAGNETOMETER_MR_REG_M, MAGNETOMETER_MODE_SINGLE.registerValue())
 	if err != nil {
 		return 0, 0, 0, err
 	}
 
-	xValue := int16(((uint16(xHigh)) << 8) + uint16(xLow))
-	yValue := int16(((uint16(yHigh)) << 8) + uint16(yLow))
-	zValue := int16(((uint16(zHigh)) << 8) + uint16(zLow))
+	for {
+		status, err = magnetometer.Status()
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		if status.DataReady {
+			return magnetometer.SenseRaw()
+		}
 
-	return xValue, yValue, zValue, nil
+		select {
+		case <-ctx.Done():
+			return 0, 0, 0, ctx.Err()
+		case <-time.After(magnetometerPollInterval):
+		}
+	}
 }
 
-func (magnetometer *Magnetometer) SetRate(mode MagnetometerRate) error {
+// Throws away the current sample and waits for the next one, so that a sample
+// converted before a settings change is never read with the new settings
+func (magnetometer *Magnetometer) waitForNewSample() error {
+	// Only continuous mode converts on its own, and SenseOnce always starts a
+	// new conversion
+	mode, err := magnetometer.GetMode()
+	if err != nil {
+		return err
+	}
+	if mode != MAGNETOMETER_MODE_CONTINUOUS {
+		return nil
+	}
+
+	_, _, _, err = magnetometer.SenseRaw()
+	if _, saturated := err.(*SaturationError); err != nil && !saturated {
+		return err
+	}
+
+	// A few sample periods is plenty
+	ctx, cancel := context.WithTimeout(context.Background(), 3*magnetometer.rate.Frequency().Period())
+	defer cancel()
+	for {
+		status, err := magnetometer.Status()
+		if err != nil {
+			return err
+		}
+		if status.DataReady {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return errors.New("Timed out waiting for a new magnetometer sample")
+		case <-time.After(magnetometerPollInterval):
+		}
+	}
+}
+
+func (magnetometer *Magnetometer) Status() (MagnetometerStatus, error) {
+	value, err := magnetometer.mmr.ReadUint8(MAGNETOMETER_SR_REG_M)
+	if err != nil {
+		return MagnetometerStatus{}, err
+	}
+	return MagnetometerStatus{
+		DataReady: readBits(uint32(value), 1, 0) == 1,
+		Lock:      readBits(uint32(value), 1, 1) == 1,
+	}, nil
+}
+
+func (magnetometer *Magnetometer) SetRate(rate MagnetometerRate) error {
 	const bits = 3
 	const shift = 2
 
 	// The only bit in here that matters is the bit 7, temperature
 	// enabled, so just always set it to 1
 	previous := uint8(0)
-	data := uint8(mode)
+	data := uint8(rate)
 	mask := uint8((1 << bits) - 1)
 	data &= mask
 	mask <<= shift
@@ -427,6 +1538,8 @@ func (magnetometer *Magnetometer) SetRate(mode MagnetometerRate) error {
 	}
 	time.Sleep(time.Millisecond * 20)
 
+	magnetometer.rate = rate
+
 	return nil
 }
 
@@ -445,7 +1558,7 @@ func (magnetometer *Magnetometer) SetGain(gain MagnetometerGain) error {
 	const bits = 3
 	const shift = 5
 
-	data := uint8(gain)
+	data := gain.registerValue()
 	currentGain, err := magnetometer.mmr.ReadUint8(MAGNETOMETER_CRB_REG_M)
 	if err != nil {
 		return err
@@ -468,14 +1581,17 @@ func (magnetometer *Magnetometer) SetGain(gain MagnetometerGain) error {
 }
 
 func (magnetometer *Magnetometer) GetGain() (MagnetometerGain, error) {
-	value, err := magnetometer.mmr.ReadUint8(MAGNETOMETER_CRA_REG_M)
+	value, err := magnetometer.mmr.ReadUint8(MAGNETOMETER_CRB_REG_M)
 	if err != nil {
 		return MAGNETOMETER_GAIN_4_0, err
 	}
 	const bits = 3
 	const shift = 5
 	gain := ((uint32(value)) >> shift) & ((1 << bits) - 1)
-	return MagnetometerGain(gain), nil
+	if gain == 0 {
+		return MAGNETOMETER_GAIN_4_0, errors.New("Unknown magnetometer gain")
+	}
+	return MagnetometerGain(gain - 1), nil
 }
 
 // The temperature sensor is technically on the same line as the magnetometer,
@@ -490,6 +1606,22 @@ func (magnetometer *Magnetometer) SenseRelativeTemperature() (physic.Temperature
 	return physic.Temperature(int64(degrees_eighths)*int64(physic.Celsius)/8 + int64(physic.ZeroCelsius)), nil
 }
 
+// The temperature sensor is only relative, so this needs to be measured
+// against a reference thermometer
+func (magnetometer *Magnetometer) SetTemperatureOffset(offset physic.Temperature) {
+	magnetometer.temperatureOffset = offset
+}
+
+// Reads the relative temperature and adds the offset from
+// SetTemperatureOffset
+func (magnetometer *Magnetometer) SenseTemperature() (physic.Temperature, error) {
+	temperature, err := magnetometer.SenseRelativeTemperature()
+	if err != nil {
+		return 0, err
+	}
+	return temperature + magnetometer.temperatureOffset, nil
+}
+
 // Returns the relative temperature in eights of a degree
 func (magnetometer *Magnetometer) senseRelativeTemperatureRaw() (int16, error) {
 	high, err := magnetometer.mmr.ReadUint8(MAGNETOMETER_TEMP_OUT_H_M)
@@ -510,37 +1642,37 @@ const MAGNETOMETER_ADDRESS = 0x1E
 
 const (
 	// Copied from the data sheet. Unused values are commented out.
-	ACCELEROMETER_IDENTIFY    = 0x0F
-	ACCELEROMETER_CTRL_REG1_A = 0x20
-	//ACCELEROMETER_CTRL_REG2_A     = 0x21
-	//ACCELEROMETER_CTRL_REG3_A     = 0x22
-	ACCELEROMETER_CTRL_REG4_A = 0x23
-	//ACCELEROMETER_CTRL_REG5_A     = 0x24
-	//ACCELEROMETER_CTRL_REG6_A     = 0x25
-	//ACCELEROMETER_REFERENCE_A     = 0x26
-	//ACCELEROMETER_STATUS_REG_A    = 0x27
-	ACCELEROMETER_OUT_X_L_A = 0x28
-	ACCELEROMETER_OUT_X_H_A = 0x29
-	ACCELEROMETER_OUT_Y_L_A = 0x2A
-	ACCELEROMETER_OUT_Y_H_A = 0x2B
-	ACCELEROMETER_OUT_Z_L_A = 0x2C
-	ACCELEROMETER_OUT_Z_H_A = 0x2D
-	//ACCELEROMETER_FIFO_CTRL_REG_A = 0x2E
-	//ACCELEROMETER_FIFO_SRC_REG_A  = 0x2F
-	//ACCELEROMETER_INT1_CFG_A      = 0x30
-	//ACCELEROMETER_INT1_SOURCE_A   = 0x31
-	//ACCELEROMETER_INT1_THS_A      = 0x32
-	//ACCELEROMETER_INT1_DURATION_A = 0x33
-	//ACCELEROMETER_INT2_CFG_A      = 0x34
-	//ACCELEROMETER_INT2_SOURCE_A   = 0x35
-	//ACCELEROMETER_INT2_THS_A      = 0x36
-	//ACCELEROMETER_INT2_DURATION_A = 0x37
-	//ACCELEROMETER_CLICK_CFG_A     = 0x38
-	//ACCELEROMETER_CLICK_SRC_A     = 0x39
-	//ACCELEROMETER_CLICK_THS_A     = 0x3A
-	//ACCELEROMETER_TIME_LATENCY_A  = 0x3B
-	//ACCELEROMETER_TIME_LIMIT_A    = 0x3C
-	//ACCELEROMETER_TIME_WINDOW_A   = 0x3D
+	ACCELEROMETER_IDENTIFY        = 0x0F
+	ACCELEROMETER_CTRL_REG1_A     = 0x20
+	ACCELEROMETER_CTRL_REG2_A     = 0x21
+	ACCELEROMETER_CTRL_REG3_A     = 0x22
+	ACCELEROMETER_CTRL_REG4_A     = 0x23
+	ACCELEROMETER_CTRL_REG5_A     = 0x24
+	ACCELEROMETER_CTRL_REG6_A     = 0x25
+	ACCELEROMETER_REFERENCE_A     = 0x26
+	ACCELEROMETER_STATUS_REG_A    = 0x27
+	ACCELEROMETER_OUT_X_L_A       = 0x28
+	ACCELEROMETER_OUT_X_H_A       = 0x29
+	ACCELEROMETER_OUT_Y_L_A       = 0x2A
+	ACCELEROMETER_OUT_Y_H_A       = 0x2B
+	ACCELEROMETER_OUT_Z_L_A       = 0x2C
+	ACCELEROMETER_OUT_Z_H_A       = 0x2D
+	ACCELEROMETER_FIFO_CTRL_REG_A = 0x2E
+	ACCELEROMETER_FIFO_SRC_REG_A  = 0x2F
+	ACCELEROMETER_INT1_CFG_A      = 0x30
+	ACCELEROMETER_INT1_SOURCE_A   = 0x31
+	ACCELEROMETER_INT1_THS_A      = 0x32
+	ACCELEROMETER_INT1_DURATION_A = 0x33
+	ACCELEROMETER_INT2_CFG_A      = 0x34
+	ACCELEROMETER_INT2_SOURCE_A   = 0x35
+	ACCELEROMETER_INT2_THS_A      = 0x36
+	ACCELEROMETER_INT2_DURATION_A = 0x37
+	ACCELEROMETER_CLICK_CFG_A     = 0x38
+	ACCELEROMETER_CLICK_SRC_A     = 0x39
+	ACCELEROMETER_CLICK_THS_A     = 0x3A
+	ACCELEROMETER_TIME_LIMIT_A    = 0x3B
+	ACCELEROMETER_TIME_LATENCY_A  = 0x3C
+	ACCELEROMETER_TIME_WINDOW_A   = 0x3D
 )
 
 const (
@@ -554,7 +1686,7 @@ const (
 	MAGNETOMETER_OUT_Z_L_M = 0x06
 	MAGNETOMETER_OUT_Y_H_M = 0x07
 	MAGNETOMETER_OUT_Y_L_M = 0x08
-	//MAGNETOMETER_SR_REG_M  = 0x09
+	MAGNETOMETER_SR_REG_M  = 0x09
 	MAGNETOMETER_IRA_REG_M = 0x0A
 	//MAGNETOMETER_IRB_REG_M = 0x0B
 	//MAGNETOMETER_IRC_REG_M = 0x0C
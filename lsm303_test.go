@@ -1,6 +1,7 @@
 package lsm303
 
 import (
+	"context"
 	"encoding/binary"
 	"periph.io/x/periph/conn/i2c"
 	"periph.io/x/periph/conn/i2c/i2ctest"
@@ -100,18 +101,23 @@ func TestNewAccelerometer(t *testing.T) {
 			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_CTRL_REG1_A, 0x57}, R: []byte{}},
 			// Read the chipId
 			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_IDENTIFY}, R: []byte{0x33}},
-			// Read range
+			// Enable block data update
 			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_CTRL_REG4_A}, R: []byte{0}},
+			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_CTRL_REG4_A, 0x80}, R: []byte{}},
+			// Read byte order
+			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_CTRL_REG4_A}, R: []byte{0x80}},
+			// Read range
+			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_CTRL_REG4_A}, R: []byte{0x80}},
 			// Write new range
-			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_CTRL_REG4_A, 0x10}, R: []byte{}},
+			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_CTRL_REG4_A, 0x90}, R: []byte{}},
 			// Read mode
 			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_CTRL_REG1_A}, R: []byte{0}},
 			// Write new mode power
 			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_CTRL_REG1_A, 0}, R: []byte{}},
 			// Read mode
-			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_CTRL_REG4_A}, R: []byte{0}},
+			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_CTRL_REG4_A}, R: []byte{0x90}},
 			// Write new mode resolution
-			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_CTRL_REG4_A, 0}, R: []byte{}},
+			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_CTRL_REG4_A, 0x90}, R: []byte{}},
 		},
 	}
 	_, err := NewAccelerometer(scenario, &DefaultAccelerometerOpts)
@@ -120,16 +126,48 @@ func TestNewAccelerometer(t *testing.T) {
 	}
 }
 
+func TestNewAccelerometerUnsetRate(t *testing.T) {
+	scenario := &i2ctest.Playback{
+		Ops: []i2ctest.IO{
+			// Leaving the rate and axes unset should still give 100 Hz with
+			// all axes enabled
+			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_CTRL_REG1_A, 0x57}, R: []byte{}},
+			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_IDENTIFY}, R: []byte{0x33}},
+			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_CTRL_REG4_A}, R: []byte{0}},
+			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_CTRL_REG4_A, 0x80}, R: []byte{}},
+			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_CTRL_REG4_A}, R: []byte{0x80}},
+			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_CTRL_REG4_A}, R: []byte{0x80}},
+			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_CTRL_REG4_A, 0x90}, R: []byte{}},
+			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_CTRL_REG1_A}, R: []byte{0x57}},
+			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_CTRL_REG1_A, 0x57}, R: []byte{}},
+			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_CTRL_REG4_A}, R: []byte{0x90}},
+			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_CTRL_REG4_A, 0x90}, R: []byte{}},
+		},
+	}
+	accelerometer, err := NewAccelerometer(scenario, &AccelerometerOpts{
+		Range: ACCELEROMETER_RANGE_4G,
+		Mode:  ACCELEROMETER_MODE_NORMAL,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if accelerometer.rate != ACCELEROMETER_RATE_100 {
+		t.Fatalf("Expected 100 Hz but was %v", accelerometer.rate)
+	}
+	if accelerometer.axes != ACCELEROMETER_AXES_ALL {
+		t.Fatalf("Expected all axes but was %v", accelerometer.axes)
+	}
+}
+
 func TestAccelerometerSense(t *testing.T) {
 	scenario := &i2ctest.Playback{
 		Ops: []i2ctest.IO{
-			// Read registers
-			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_OUT_X_L_A}, R: []byte{0}},
-			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_OUT_X_H_A}, R: []byte{1}},
-			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_OUT_Y_L_A}, R: []byte{100}},
-			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_OUT_Y_H_A}, R: []byte{0}},
-			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_OUT_Z_L_A}, R: []byte{0xff}},
-			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_OUT_Z_H_A}, R: []byte{0xff}},
+			// Read all of the registers in one transaction
+			{
+				Addr: ACCELEROMETER_ADDRESS,
+				W:    []byte{ACCELEROMETER_OUT_X_L_A | 0x80},
+				R:    []byte{0, 1, 100, 0, 0xff, 0xff},
+			},
 		},
 	}
 
@@ -140,6 +178,7 @@ func TestAccelerometerSense(t *testing.T) {
 		},
 		range_: ACCELEROMETER_RANGE_4G,
 		mode:   ACCELEROMETER_MODE_NORMAL,
+		axes:   ACCELEROMETER_AXES_ALL,
 	}
 
 	x, y, z, err := accelerometer.SenseRaw()
@@ -158,15 +197,432 @@ func TestAccelerometerSense(t *testing.T) {
 	}
 }
 
+func TestAccelerometerBigEndian(t *testing.T) {
+	scenario := &i2ctest.Playback{
+		Ops: []i2ctest.IO{
+			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_CTRL_REG4_A}, R: []byte{0x80}},
+			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_CTRL_REG4_A, 0xC0}, R: []byte{}},
+			{
+				Addr: ACCELEROMETER_ADDRESS,
+				W:    []byte{ACCELEROMETER_OUT_X_L_A | 0x80},
+				R:    []byte{1, 0, 0, 100, 0xff, 0xff},
+			},
+			// Something else switched it back
+			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_CTRL_REG4_A}, R: []byte{0x80}},
+			{
+				Addr: ACCELEROMETER_ADDRESS,
+				W:    []byte{ACCELEROMETER_OUT_X_L_A | 0x80},
+				R:    []byte{1, 0, 0, 100, 0xff, 0xff},
+			},
+		},
+	}
+
+	accelerometer := &Accelerometer{
+		mmr: mmr.Dev8{
+			Conn:  &i2c.Dev{Bus: scenario, Addr: uint16(ACCELEROMETER_ADDRESS)},
+			Order: binary.BigEndian,
+		},
+		axes: ACCELEROMETER_AXES_ALL,
+	}
+
+	err := accelerometer.SetByteOrder(ACCELEROMETER_BYTE_ORDER_BIG_ENDIAN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	x, y, z, err := accelerometer.SenseRaw()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if x != 256 || y != 100 || z != -1 {
+		t.Fatalf("Bad big endian sample %v %v %v", x, y, z)
+	}
+
+	order, err := accelerometer.GetByteOrder()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if order != ACCELEROMETER_BYTE_ORDER_LITTLE_ENDIAN {
+		t.Fatalf("Expected little endian but was %v", order)
+	}
+	x, y, z, err = accelerometer.SenseRaw()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if x != 1 || y != 25600 || z != -1 {
+		t.Fatalf("Bad little endian sample %v %v %v", x, y, z)
+	}
+}
+
+func TestAccelerometerDisabledAxes(t *testing.T) {
+	scenario := &i2ctest.Playback{
+		Ops: []i2ctest.IO{
+			// Disable Y
+			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_CTRL_REG1_A}, R: []byte{0x57}},
+			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_CTRL_REG1_A, 0x55}, R: []byte{}},
+			// Y still has stale data, but it's ignored
+			{
+				Addr: ACCELEROMETER_ADDRESS,
+				W:    []byte{ACCELEROMETER_OUT_X_L_A | 0x80},
+				R:    []byte{0, 1, 100, 0, 0xff, 0xff},
+			},
+			// Disable everything
+			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_CTRL_REG1_A}, R: []byte{0x55}},
+			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_CTRL_REG1_A, 0x50}, R: []byte{}},
+		},
+	}
+
+	accelerometer := &Accelerometer{
+		mmr: mmr.Dev8{
+			Conn:  &i2c.Dev{Bus: scenario, Addr: uint16(ACCELEROMETER_ADDRESS)},
+			Order: binary.BigEndian,
+		},
+		range_: ACCELEROMETER_RANGE_4G,
+		mode:   ACCELEROMETER_MODE_NORMAL,
+		axes:   ACCELEROMETER_AXES_ALL,
+	}
+
+	err := accelerometer.SetEnabledAxes(ACCELEROMETER_AXIS_X | ACCELEROMETER_AXIS_Z)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sample, err := accelerometer.SenseSample()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sample.X != 256 {
+		t.Fatal("Bad x")
+	}
+	if sample.Y != 0 {
+		t.Fatal("Disabled y should be 0")
+	}
+	if sample.Z != -1 {
+		t.Fatal("Bad z")
+	}
+	if sample.Axes != ACCELEROMETER_AXIS_X|ACCELEROMETER_AXIS_Z {
+		t.Fatalf("Expected X and Z enabled but was %v", sample.Axes)
+	}
+
+	err = accelerometer.SetEnabledAxes(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, _, err = accelerometer.SenseRaw()
+	if err == nil {
+		t.Fatal("Reading with no axes enabled should fail")
+	}
+}
+
+func TestAccelerometerHighPassFilter(t *testing.T) {
+	scenario := &i2ctest.Playback{
+		Ops: []i2ctest.IO{
+			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_CTRL_REG2_A, 0b10101001}, R: []byte{}},
+			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_CTRL_REG2_A}, R: []byte{0b11010110}},
+		},
+	}
+
+	accelerometer := &Accelerometer{
+		mmr: mmr.Dev8{
+			Conn:  &i2c.Dev{Bus: scenario, Addr: uint16(ACCELEROMETER_ADDRESS)},
+			Order: binary.BigEndian,
+		},
+	}
+
+	err := accelerometer.SetHighPassFilter(AccelerometerHighPassFilter{
+		Mode:       ACCELEROMETER_HIGH_PASS_MODE_NORMAL,
+		Cutoff:     ACCELEROMETER_HIGH_PASS_CUTOFF_2,
+		Output:     true,
+		Interrupt1: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	filter, err := accelerometer.GetHighPassFilter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := AccelerometerHighPassFilter{
+		Mode:       ACCELEROMETER_HIGH_PASS_MODE_AUTORESET,
+		Cutoff:     ACCELEROMETER_HIGH_PASS_CUTOFF_1,
+		Click:      true,
+		Interrupt2: true,
+	}
+	if filter != expected {
+		t.Fatalf("Expected %+v but was %+v", expected, filter)
+	}
+}
+
+func TestAccelerometerFifo(t *testing.T) {
+	scenario := &i2ctest.Playback{
+		Ops: []i2ctest.IO{
+			// Enable the FIFO
+			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_CTRL_REG5_A}, R: []byte{0}},
+			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_CTRL_REG5_A, 0x40}, R: []byte{}},
+			// Clear, then stream mode with a watermark of 16
+			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_FIFO_CTRL_REG_A, 0}, R: []byte{}},
+			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_FIFO_CTRL_REG_A, 0x90}, R: []byte{}},
+			// Status, 2 samples
+			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_FIFO_SRC_REG_A}, R: []byte{0x02}},
+			// Burst read both samples
+			{
+				Addr: ACCELEROMETER_ADDRESS,
+				W:    []byte{ACCELEROMETER_OUT_X_L_A | 0x80},
+				R:    []byte{0, 1, 100, 0, 0xff, 0xff, 1, 0, 2, 0, 3, 0},
+			},
+			// Overrun
+			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_FIFO_SRC_REG_A}, R: []byte{0xDF}},
+		},
+	}
+
+	accelerometer := &Accelerometer{
+		mmr: mmr.Dev8{
+			Conn:  &i2c.Dev{Bus: scenario, Addr: uint16(ACCELEROMETER_ADDRESS)},
+			Order: binary.BigEndian,
+		},
+		axes: ACCELEROMETER_AXES_ALL,
+	}
+
+	err := accelerometer.SetFifo(AccelerometerFifo{Mode: ACCELEROMETER_FIFO_MODE_STREAM, Watermark: 16})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	samples := make([]AccelerometerSample, ACCELEROMETER_FIFO_SIZE)
+	count, err := accelerometer.ReadFifo(samples)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Fatalf("Expected 2 samples but read %v", count)
+	}
+	if samples[0] != (AccelerometerSample{X: 256, Y: 100, Z: -1, Axes: ACCELEROMETER_AXES_ALL}) {
+		t.Fatalf("Bad first sample %v", samples[0])
+	}
+	if samples[1] != (AccelerometerSample{X: 1, Y: 2, Z: 3, Axes: ACCELEROMETER_AXES_ALL}) {
+		t.Fatalf("Bad second sample %v", samples[1])
+	}
+
+	status, err := accelerometer.GetFifoStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := AccelerometerFifoStatus{Samples: ACCELEROMETER_FIFO_SIZE, Watermark: true, Overrun: true}
+	if status != expected {
+		t.Fatalf("Expected %+v but was %+v", expected, status)
+	}
+
+	err = accelerometer.SetFifo(AccelerometerFifo{Watermark: ACCELEROMETER_FIFO_SIZE})
+	if err == nil {
+		t.Fatal("Watermark should be rejected")
+	}
+}
+
+func TestAccelerometerReset(t *testing.T) {
+	ops := []i2ctest.IO{
+		// Reboot
+		{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_CTRL_REG5_A}, R: []byte{0x48}},
+		{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_CTRL_REG5_A, 0xC8}, R: []byte{}},
+	}
+	for _, register := range []uint8{
+		ACCELEROMETER_CTRL_REG3_A,
+		ACCELEROMETER_CTRL_REG6_A,
+		ACCELEROMETER_CTRL_REG5_A,
+		ACCELEROMETER_FIFO_CTRL_REG_A,
+		ACCELEROMETER_INT1_CFG_A,
+		ACCELEROMETER_INT1_THS_A,
+		ACCELEROMETER_INT1_DURATION_A,
+		ACCELEROMETER_INT2_CFG_A,
+		ACCELEROMETER_INT2_THS_A,
+		ACCELEROMETER_INT2_DURATION_A,
+		ACCELEROMETER_CLICK_CFG_A,
+		ACCELEROMETER_CLICK_THS_A,
+		ACCELEROMETER_TIME_LIMIT_A,
+		ACCELEROMETER_TIME_LATENCY_A,
+		ACCELEROMETER_TIME_WINDOW_A,
+		ACCELEROMETER_REFERENCE_A,
+		ACCELEROMETER_CTRL_REG2_A,
+		ACCELEROMETER_CTRL_REG4_A,
+	} {
+		ops = append(ops, i2ctest.IO{Addr: ACCELEROMETER_ADDRESS, W: []byte{register, 0}, R: []byte{}})
+	}
+	ops = append(ops, i2ctest.IO{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_CTRL_REG1_A, 0x07}, R: []byte{}})
+	scenario := &i2ctest.Playback{Ops: ops}
+
+	accelerometer := &Accelerometer{
+		mmr: mmr.Dev8{
+			Conn:  &i2c.Dev{Bus: scenario, Addr: uint16(ACCELEROMETER_ADDRESS)},
+			Order: binary.BigEndian,
+		},
+		range_:       ACCELEROMETER_RANGE_16G,
+		rate:         ACCELEROMETER_RATE_400,
+		bigEndian:    true,
+		clickEnabled: true,
+	}
+
+	err := accelerometer.Reset()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if accelerometer.range_ != ACCELEROMETER_RANGE_2G {
+		t.Fatal("Range should be reset")
+	}
+	if accelerometer.rate != ACCELEROMETER_RATE_POWER_DOWN {
+		t.Fatal("Rate should be reset")
+	}
+	if accelerometer.bigEndian || accelerometer.clickEnabled {
+		t.Fatal("Settings should be reset")
+	}
+}
+
+func TestAccelerometerWaitForSample(t *testing.T) {
+	scenario := &i2ctest.Playback{
+		Ops: []i2ctest.IO{
+			// Nothing new yet
+			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_STATUS_REG_A}, R: []byte{0x00}},
+			// New data, with an overrun
+			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_STATUS_REG_A}, R: []byte{0xFF}},
+			{
+				Addr: ACCELEROMETER_ADDRESS,
+				W:    []byte{ACCELEROMETER_OUT_X_L_A | 0x80},
+				R:    []byte{0, 1, 100, 0, 0xff, 0xff},
+			},
+			// Only X is new
+			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_STATUS_REG_A}, R: []byte{0x01}},
+		},
+	}
+
+	accelerometer := &Accelerometer{
+		mmr: mmr.Dev8{
+			Conn:  &i2c.Dev{Bus: scenario, Addr: uint16(ACCELEROMETER_ADDRESS)},
+			Order: binary.BigEndian,
+		},
+		range_: ACCELEROMETER_RANGE_4G,
+		mode:   ACCELEROMETER_MODE_NORMAL,
+		rate:   ACCELEROMETER_RATE_400,
+		axes:   ACCELEROMETER_AXES_ALL,
+	}
+
+	sample, err := accelerometer.WaitForSample(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := AccelerometerSample{X: 256, Y: 100, Z: -1, Overrun: true, Range: ACCELEROMETER_RANGE_4G, Axes: ACCELEROMETER_AXES_ALL}
+	if sample != expected {
+		t.Fatalf("Expected %+v but was %+v", expected, sample)
+	}
+
+	status, err := accelerometer.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedStatus := AccelerometerStatus{AxesAvailable: ACCELEROMETER_AXIS_X}
+	if status != expectedStatus {
+		t.Fatalf("Expected %+v but was %+v", expectedStatus, status)
+	}
+}
+
+func TestAccelerometerWaitForSampleCancel(t *testing.T) {
+	scenario := &i2ctest.Playback{
+		Ops: []i2ctest.IO{
+			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_STATUS_REG_A}, R: []byte{0x00}},
+		},
+	}
+
+	accelerometer := &Accelerometer{
+		mmr: mmr.Dev8{
+			Conn:  &i2c.Dev{Bus: scenario, Addr: uint16(ACCELEROMETER_ADDRESS)},
+			Order: binary.BigEndian,
+		},
+		rate: ACCELEROMETER_RATE_1,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := accelerometer.WaitForSample(ctx)
+	if err != context.Canceled {
+		t.Fatalf("Expected context.Canceled but was %v", err)
+	}
+}
+
+func TestMagnetometerSenseOnce(t *testing.T) {
+	scenario := &i2ctest.Playback{
+		Ops: []i2ctest.IO{
+			// An old sample is waiting, so throw it away
+			{Addr: MAGNETOMETER_ADDRESS, W: []byte{MAGNETOMETER_SR_REG_M}, R: []byte{0x01}},
+			{Addr: MAGNETOMETER_ADDRESS, W: []byte{MAGNETOMETER_OUT_X_H_M}, R: []byte{9, 9, 9, 9, 9, 9}},
+			// Trigger a single conversion
+			{Addr: MAGNETOMETER_ADDRESS, W: []byte{MAGNETOMETER_MR_REG_M, 0x01}, R: []byte{}},
+			{Addr: MAGNETOMETER_ADDRESS, W: []byte{MAGNETOMETER_SR_REG_M}, R: []byte{0x00}},
+			{Addr: MAGNETOMETER_ADDRESS, W: []byte{MAGNETOMETER_SR_REG_M}, R: []byte{0x01}},
+			{Addr: MAGNETOMETER_ADDRESS, W: []byte{MAGNETOMETER_OUT_X_H_M}, R: []byte{1, 0, 0xff, 0xff, 0, 100}},
+			// Sleep
+			{Addr: MAGNETOMETER_ADDRESS, W: []byte{MAGNETOMETER_MR_REG_M, 0x03}, R: []byte{}},
+			{Addr: MAGNETOMETER_ADDRESS, W: []byte{MAGNETOMETER_MR_REG_M}, R: []byte{0x02}},
+		},
+	}
+
+	magnetometer := &Magnetometer{
+		mmr: mmr.Dev8{
+			Conn:  &i2c.Dev{Bus: scenario, Addr: uint16(MAGNETOMETER_ADDRESS)},
+			Order: binary.BigEndian,
+		},
+	}
+
+	x, y, z, err := magnetometer.SenseOnce(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if x != 256 || y != 100 || z != -1 {
+		t.Fatalf("Bad sample %v %v %v", x, y, z)
+	}
+
+	err = magnetometer.Sleep()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mode, err := magnetometer.GetMode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode != MAGNETOMETER_MODE_SLEEP {
+		t.Fatalf("Expected sleep but was %v", mode)
+	}
+}
+
+func TestMagnetometerStatus(t *testing.T) {
+	scenario := &i2ctest.Playback{
+		Ops: []i2ctest.IO{
+			{Addr: MAGNETOMETER_ADDRESS, W: []byte{MAGNETOMETER_SR_REG_M}, R: []byte{0x03}},
+		},
+	}
+
+	magnetometer := &Magnetometer{
+		mmr: mmr.Dev8{
+			Conn:  &i2c.Dev{Bus: scenario, Addr: uint16(MAGNETOMETER_ADDRESS)},
+			Order: binary.BigEndian,
+		},
+	}
+
+	status, err := magnetometer.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != (MagnetometerStatus{DataReady: true, Lock: true}) {
+		t.Fatalf("Bad status %+v", status)
+	}
+}
+
 func TestNewMagnetometer(t *testing.T) {
 	scenario := &i2ctest.Playback{
 		Ops: []i2ctest.IO{
+			// Enable the magnetometer
+			{Addr: MAGNETOMETER_ADDRESS, W: []byte{MAGNETOMETER_MR_REG_M, 0}, R: []byte{}},
 			// Read the chip ID (not a real ID, just a constant)
 			{Addr: MAGNETOMETER_ADDRESS, W: []byte{MAGNETOMETER_IRA_REG_M}, R: []byte{0b01001000}},
 			// Read gain
 			{Addr: MAGNETOMETER_ADDRESS, W: []byte{MAGNETOMETER_CRB_REG_M}, R: []byte{0}},
 			// Write new gain
-			{Addr: MAGNETOMETER_ADDRESS, W: []byte{MAGNETOMETER_CRB_REG_M, uint8(DefaultMagnetometerOpts.Gain) << 5}, R: []byte{}},
+			{Addr: MAGNETOMETER_ADDRESS, W: []byte{MAGNETOMETER_CRB_REG_M, (uint8(DefaultMagnetometerOpts.Gain) + 1) << 5}, R: []byte{}},
 			// Write new rate
 			{Addr: MAGNETOMETER_ADDRESS, W: []byte{MAGNETOMETER_CRA_REG_M, (uint8(DefaultMagnetometerOpts.Rate) << 2) | 0b10000000}, R: []byte{}},
 		},
@@ -177,16 +633,45 @@ func TestNewMagnetometer(t *testing.T) {
 	}
 }
 
+func TestNewMagnetometerReset(t *testing.T) {
+	scenario := &i2ctest.Playback{
+		Ops: []i2ctest.IO{
+			// Reset
+			{Addr: MAGNETOMETER_ADDRESS, W: []byte{MAGNETOMETER_MR_REG_M, 0x03}, R: []byte{}},
+			{Addr: MAGNETOMETER_ADDRESS, W: []byte{MAGNETOMETER_CRA_REG_M, 0x10}, R: []byte{}},
+			{Addr: MAGNETOMETER_ADDRESS, W: []byte{MAGNETOMETER_CRB_REG_M, 0x20}, R: []byte{}},
+			// Then the same as without reset
+			{Addr: MAGNETOMETER_ADDRESS, W: []byte{MAGNETOMETER_MR_REG_M, 0}, R: []byte{}},
+			{Addr: MAGNETOMETER_ADDRESS, W: []byte{MAGNETOMETER_IRA_REG_M}, R: []byte{0b01001000}},
+			{Addr: MAGNETOMETER_ADDRESS, W: []byte{MAGNETOMETER_CRB_REG_M}, R: []byte{0x20}},
+			{Addr: MAGNETOMETER_ADDRESS, W: []byte{MAGNETOMETER_CRB_REG_M, (uint8(DefaultMagnetometerOpts.Gain) + 1) << 5}, R: []byte{}},
+			{Addr: MAGNETOMETER_ADDRESS, W: []byte{MAGNETOMETER_CRA_REG_M, (uint8(DefaultMagnetometerOpts.Rate) << 2) | 0b10000000}, R: []byte{}},
+		},
+	}
+	opts := DefaultMagnetometerOpts
+	opts.Reset = true
+	magnetometer, err := NewMagnetometer(scenario, &opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if magnetometer.gain != DefaultMagnetometerOpts.Gain {
+		t.Fatalf("Expected gain %v but was %v", DefaultMagnetometerOpts.Gain, magnetometer.gain)
+	}
+	if magnetometer.rate != DefaultMagnetometerOpts.Rate {
+		t.Fatalf("Expected rate %v but was %v", DefaultMagnetometerOpts.Rate, magnetometer.rate)
+	}
+}
+
 func TestMagnetometerSense(t *testing.T) {
 	scenario := &i2ctest.Playback{
 		Ops: []i2ctest.IO{
-			// Read registers
-			{Addr: MAGNETOMETER_ADDRESS, W: []byte{MAGNETOMETER_OUT_X_L_M}, R: []byte{0}},
-			{Addr: MAGNETOMETER_ADDRESS, W: []byte{MAGNETOMETER_OUT_X_H_M}, R: []byte{1}},
-			{Addr: MAGNETOMETER_ADDRESS, W: []byte{MAGNETOMETER_OUT_Y_L_M}, R: []byte{100}},
-			{Addr: MAGNETOMETER_ADDRESS, W: []byte{MAGNETOMETER_OUT_Y_H_M}, R: []byte{0}},
-			{Addr: MAGNETOMETER_ADDRESS, W: []byte{MAGNETOMETER_OUT_Z_L_M}, R: []byte{0xff}},
-			{Addr: MAGNETOMETER_ADDRESS, W: []byte{MAGNETOMETER_OUT_Z_H_M}, R: []byte{0xff}},
+			// Read all of the registers in one transaction, X Z Y high byte
+			// first
+			{
+				Addr: MAGNETOMETER_ADDRESS,
+				W:    []byte{MAGNETOMETER_OUT_X_H_M},
+				R:    []byte{1, 0, 0xff, 0xff, 0, 100},
+			},
 		},
 	}
 
@@ -215,7 +700,54 @@ func TestMagnetometerSense(t *testing.T) {
 	}
 }
 
-func TestGetTemperature(t *testing.T) {
+func TestConvertMagnetometer(t *testing.T) {
+	// The X and Y axes are more sensitive than Z, so the same raw value
+	// should give a smaller Z field
+	x, y, z := convertMagnetometer(1100, -1100, 980, MAGNETOMETER_GAIN_1_3)
+	if x != Gauss || y != -Gauss || z != Gauss {
+		t.Fatalf("Expected 1 gauss but was %v %v %v", x, y, z)
+	}
+	x, y, z = convertMagnetometer(230, 0, 205, MAGNETOMETER_GAIN_8_1)
+	if x != Gauss || y != 0 || z != Gauss {
+		t.Fatalf("Expected 1 gauss but was %v %v %v", x, y, z)
+	}
+	// The same field should read the same at any gain
+	x, _, _ = convertMagnetometer(225, 0, 0, MAGNETOMETER_GAIN_4_0)
+	if x != 50*Microtesla {
+		t.Fatalf("Expected 50 µT but was %v", x)
+	}
+}
+
+func TestMagnetometerGain(t *testing.T) {
+	scenario := &i2ctest.Playback{
+		Ops: []i2ctest.IO{
+			{Addr: MAGNETOMETER_ADDRESS, W: []byte{MAGNETOMETER_CRB_REG_M}, R: []byte{0x20}},
+			{Addr: MAGNETOMETER_ADDRESS, W: []byte{MAGNETOMETER_CRB_REG_M, 0xE0}, R: []byte{}},
+			{Addr: MAGNETOMETER_ADDRESS, W: []byte{MAGNETOMETER_CRB_REG_M}, R: []byte{0xE0}},
+		},
+	}
+
+	magnetometer := &Magnetometer{
+		mmr: mmr.Dev8{
+			Conn:  &i2c.Dev{Bus: scenario, Addr: uint16(MAGNETOMETER_ADDRESS)},
+			Order: binary.BigEndian,
+		},
+	}
+
+	err := magnetometer.SetGain(MAGNETOMETER_GAIN_8_1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gain, err := magnetometer.GetGain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gain != MAGNETOMETER_GAIN_8_1 {
+		t.Fatalf("Expected 8.1 but was %v", gain)
+	}
+}
+
+func TestSenseRelativeTemperature(t *testing.T) {
 	scenario := &i2ctest.Playback{
 		Ops: []i2ctest.IO{
 			{Addr: MAGNETOMETER_ADDRESS, W: []byte{MAGNETOMETER_TEMP_OUT_H_M}, R: []byte{0}},
@@ -236,28 +768,83 @@ func TestGetTemperature(t *testing.T) {
 		rate: MAGNETOMETER_RATE_30,
 	}
 
-	temperature, err := magnetometer.GetTemperature()
+	temperature, err := magnetometer.SenseRelativeTemperature()
 	if err != nil {
 		t.Fatal(err)
 	}
-	const offset = 20
-	if temperature != physic.ZeroCelsius+offset*physic.Celsius {
+	if temperature != physic.ZeroCelsius {
 		t.Fatal("Not 0 C")
 	}
 
-	temperature, err = magnetometer.GetTemperature()
+	temperature, err = magnetometer.SenseRelativeTemperature()
 	if err != nil {
 		t.Fatal(err)
 	}
-	if temperature != physic.ZeroCelsius+physic.Celsius+offset*physic.Celsius {
+	if temperature != physic.ZeroCelsius+physic.Celsius {
 		t.Fatal("Not 1 C")
 	}
 
-	temperature, err = magnetometer.GetTemperature()
+	temperature, err = magnetometer.SenseRelativeTemperature()
 	if err != nil {
 		t.Fatal(err)
 	}
-	if temperature != physic.ZeroCelsius-physic.Celsius+offset*physic.Celsius {
+	if temperature != physic.ZeroCelsius-physic.Celsius {
 		t.Fatal("Not -1 C")
 	}
 }
+
+func TestAccelerometerDataRate(t *testing.T) {
+	scenario := &i2ctest.Playback{
+		Ops: []i2ctest.IO{
+			// Set 400 Hz
+			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_CTRL_REG1_A}, R: []byte{0x57}},
+			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_CTRL_REG1_A, 0x77}, R: []byte{}},
+			// Read 400 Hz
+			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_CTRL_REG1_A}, R: []byte{0x77}},
+			// Read 5.376 kHz, low power bit set
+			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_CTRL_REG1_A}, R: []byte{0x9F}},
+		},
+	}
+
+	accelerometer := &Accelerometer{
+		mmr: mmr.Dev8{
+			Conn:  &i2c.Dev{Bus: scenario, Addr: uint16(ACCELEROMETER_ADDRESS)},
+			Order: binary.BigEndian,
+		},
+		range_: ACCELEROMETER_RANGE_4G,
+		mode:   ACCELEROMETER_MODE_NORMAL,
+		rate:   ACCELEROMETER_RATE_100,
+	}
+
+	err := accelerometer.SetDataRate(ACCELEROMETER_RATE_400)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rate, err := accelerometer.GetDataRate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rate != ACCELEROMETER_RATE_400 {
+		t.Fatalf("Expected 400 Hz but was %v", rate)
+	}
+	rate, err = accelerometer.GetDataRate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rate != ACCELEROMETER_RATE_5376_LOW_POWER {
+		t.Fatalf("Expected 5376 Hz but was %v", rate)
+	}
+
+	// Low power only rates should be rejected without touching the device
+	err = accelerometer.SetDataRate(ACCELEROMETER_RATE_1620_LOW_POWER)
+	if err == nil {
+		t.Fatal("1620 Hz should be rejected in normal mode")
+	}
+	err = accelerometer.SetDataRate(ACCELEROMETER_RATE_5376_LOW_POWER)
+	if err == nil {
+		t.Fatal("5376 Hz should be rejected in normal mode")
+	}
+	if accelerometer.rate != ACCELEROMETER_RATE_400 {
+		t.Fatal("Rejected rate should not be stored")
+	}
+}
@@ -0,0 +1,124 @@
+package lsm303
+
+import (
+	"context"
+	"periph.io/x/periph/conn/physic"
+	"time"
+)
+
+// Which axis is pointing up, as detected by the 6D position recognition
+type Orientation int
+
+const (
+	ORIENTATION_UNKNOWN Orientation = iota
+	ORIENTATION_X_UP
+	ORIENTATION_X_DOWN
+	ORIENTATION_Y_UP
+	ORIENTATION_Y_DOWN
+	// Z up
+	ORIENTATION_FACE_UP
+	// Z down
+	ORIENTATION_FACE_DOWN
+)
+
+func (orientation Orientation) String() string {
+	return [...]string{"unknown", "X up", "X down", "Y up", "Y down", "face up", "face down"}[orientation]
+}
+
+type OrientationDetection int
+
+const (
+	// Detects all 6 orientations
+	ORIENTATION_DETECTION_6D OrientationDetection = iota
+	// Ignores the Z axis, so only detects X and Y up and down. This is useful
+	// for display rotation.
+	ORIENTATION_DETECTION_4D
+)
+
+func (detection OrientationDetection) String() string {
+	return [...]string{"6D", "4D"}[detection]
+}
+
+// Configures interrupt 1 for position recognition. An axis is considered to
+// be pointing up or down when its acceleration is above the threshold for at
+// least the duration. The data sheet suggests a threshold around 0.5 G.
+func (accelerometer *Accelerometer) ConfigureOrientation(detection OrientationDetection, threshold physic.Force, duration time.Duration) error {
+	events := INTERRUPT_LOW | INTERRUPT_HIGH
+	if detection == ORIENTATION_DETECTION_4D {
+		events &^= INTERRUPT_Z_LOW | INTERRUPT_Z_HIGH
+	}
+	return accelerometer.ConfigureInterrupt(ACCELEROMETER_INTERRUPT_1, InterruptConfig{
+		Events:      events,
+		Combination: INTERRUPT_COMBINATION_6D_POSITION,
+		Threshold:   threshold,
+		Duration:    duration,
+		FourD:       detection == ORIENTATION_DETECTION_4D,
+	})
+}
+
+// Reads the current orientation. Returns ORIENTATION_UNKNOWN if the device is
+// between orientations.
+func (accelerometer *Accelerometer) ReadOrientation() (Orientation, error) {
+	source, err := accelerometer.ReadInterruptSource(ACCELEROMETER_INTERRUPT_1)
+	if err != nil {
+		return ORIENTATION_UNKNOWN, err
+	}
+	return decodeOrientation(source), nil
+}
+
+// Polls the orientation and sends it on the returned channel whenever it
+// changes. The current orientation is sent first. The channel is closed when
+// the context is done or a read fails.
+func (accelerometer *Accelerometer) WatchOrientation(ctx context.Context, interval time.Duration) <-chan Orientation {
+	changes := make(chan Orientation)
+	go func() {
+		defer close(changes)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		previous := Orientation(-1)
+		for {
+			orientation, err := accelerometer.ReadOrientation()
+			if err != nil {
+				return
+			}
+			if orientation != previous {
+				select {
+				case changes <- orientation:
+				case <-ctx.Done():
+					return
+				}
+				previous = orientation
+			}
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return changes
+}
+
+// In position mode, exactly one event is set when the device is in a known
+// orientation. A high event means the axis is pointing up.
+func decodeOrientation(source InterruptSource) Orientation {
+	if !source.Active {
+		return ORIENTATION_UNKNOWN
+	}
+	switch source.Events {
+	case INTERRUPT_X_HIGH:
+		return ORIENTATION_X_UP
+	case INTERRUPT_X_LOW:
+		return ORIENTATION_X_DOWN
+	case INTERRUPT_Y_HIGH:
+		return ORIENTATION_Y_UP
+	case INTERRUPT_Y_LOW:
+		return ORIENTATION_Y_DOWN
+	case INTERRUPT_Z_HIGH:
+		return ORIENTATION_FACE_UP
+	case INTERRUPT_Z_LOW:
+		return ORIENTATION_FACE_DOWN
+	}
+	return ORIENTATION_UNKNOWN
+}
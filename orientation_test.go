@@ -0,0 +1,114 @@
+package lsm303
+
+import (
+	"context"
+	"encoding/binary"
+	"periph.io/x/periph/conn/i2c"
+	"periph.io/x/periph/conn/i2c/i2ctest"
+	"periph.io/x/periph/conn/mmr"
+	"periph.io/x/periph/conn/physic"
+	"testing"
+	"time"
+)
+
+func TestConfigureOrientation(t *testing.T) {
+	scenario := &i2ctest.Playback{
+		Ops: []i2ctest.IO{
+			// 0.5 G / 16 mG = 31
+			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_INT1_THS_A, 31}, R: []byte{}},
+			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_INT1_DURATION_A, 0}, R: []byte{}},
+			// 6D position, X and Y events
+			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_INT1_CFG_A, 0xCF}, R: []byte{}},
+			// D4D_INT1
+			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_CTRL_REG5_A}, R: []byte{0x08}},
+			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_CTRL_REG5_A, 0x04}, R: []byte{}},
+			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_CTRL_REG3_A}, R: []byte{0}},
+			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_CTRL_REG3_A, 0x40}, R: []byte{}},
+			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_INT1_SOURCE_A}, R: []byte{0x44}},
+		},
+	}
+
+	accelerometer := &Accelerometer{
+		mmr: mmr.Dev8{
+			Conn:  &i2c.Dev{Bus: scenario, Addr: uint16(ACCELEROMETER_ADDRESS)},
+			Order: binary.BigEndian,
+		},
+		range_: ACCELEROMETER_RANGE_2G,
+		mode:   ACCELEROMETER_MODE_NORMAL,
+		rate:   ACCELEROMETER_RATE_100,
+	}
+
+	err := accelerometer.ConfigureOrientation(ORIENTATION_DETECTION_4D, physic.EarthGravity/2, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	orientation, err := accelerometer.ReadOrientation()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if orientation != ORIENTATION_Y_DOWN {
+		t.Fatalf("Expected Y down but was %v", orientation)
+	}
+}
+
+func TestDecodeOrientation(t *testing.T) {
+	tests := []struct {
+		source   uint8
+		expected Orientation
+	}{
+		{0x00, ORIENTATION_UNKNOWN},
+		{0x41, ORIENTATION_X_DOWN},
+		{0x42, ORIENTATION_X_UP},
+		{0x44, ORIENTATION_Y_DOWN},
+		{0x48, ORIENTATION_Y_UP},
+		{0x50, ORIENTATION_FACE_DOWN},
+		{0x60, ORIENTATION_FACE_UP},
+		// Not active
+		{0x20, ORIENTATION_UNKNOWN},
+		// Between orientations
+		{0x60 | 0x02, ORIENTATION_UNKNOWN},
+	}
+	for _, test := range tests {
+		orientation := decodeOrientation(decodeInterruptSource(test.source))
+		if orientation != test.expected {
+			t.Errorf("decodeOrientation(%#x) should be %v but was %v", test.source, test.expected, orientation)
+		}
+	}
+}
+
+func TestWatchOrientation(t *testing.T) {
+	scenario := &i2ctest.Playback{
+		Ops: []i2ctest.IO{
+			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_INT1_SOURCE_A}, R: []byte{0x60}},
+			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_INT1_SOURCE_A}, R: []byte{0x60}},
+			{Addr: ACCELEROMETER_ADDRESS, W: []byte{ACCELEROMETER_INT1_SOURCE_A}, R: []byte{0x42}},
+		},
+		DontPanic: true,
+	}
+
+	accelerometer := &Accelerometer{
+		mmr: mmr.Dev8{
+			Conn:  &i2c.Dev{Bus: scenario, Addr: uint16(ACCELEROMETER_ADDRESS)},
+			Order: binary.BigEndian,
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changes := accelerometer.WatchOrientation(ctx, time.Millisecond)
+	expected := []Orientation{ORIENTATION_FACE_UP, ORIENTATION_X_UP}
+	for _, orientation := range expected {
+		change, ok := <-changes
+		if !ok {
+			t.Fatal("Channel closed early")
+		}
+		if change != orientation {
+			t.Fatalf("Expected %v but was %v", orientation, change)
+		}
+	}
+	// The playback runs out, so the read fails and the channel is closed
+	_, ok := <-changes
+	if ok {
+		t.Fatal("Channel should be closed")
+	}
+}
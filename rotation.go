@@ -0,0 +1,257 @@
+package lsm303
+
+import (
+	"errors"
+	"math"
+	"periph.io/x/periph/conn/physic"
+)
+
+// The world and body axes that a rotation is expressed in
+type Frame int
+
+const (
+	// World axes are north, east, down, and body axes are forward, right,
+	// down. This is the usual aerospace convention.
+	FRAME_NED Frame = iota
+	// World axes are east, north, up, and body axes are forward, left, up.
+	// This is the convention used by ROS and many 3D renderers.
+	FRAME_ENU
+)
+
+func (frame Frame) String() string {
+	return [...]string{"NED", "ENU"}[frame]
+}
+
+// A rotation from body axes to world axes, stored row-major, so that
+// world = matrix * body
+type RotationMatrix [3][3]float64
+
+// A unit quaternion rotating from body axes to world axes, with W as the
+// scalar part
+type Quaternion struct {
+	W, X, Y, Z float64
+}
+
+// Angles applied in the order yaw, pitch, roll, about the body's Z, Y and X
+// axes. In NED, yaw is the heading clockwise from north and positive pitch is
+// nose up. In ENU, yaw is counterclockwise from east and, because the body's
+// Y axis points left, positive pitch is nose down.
+type EulerAngles struct {
+	Roll  physic.Angle
+	Pitch physic.Angle
+	// From -180 to 180 degrees
+	Yaw physic.Angle
+}
+
+var IdentityRotation = RotationMatrix{{1, 0, 0}, {0, 1, 0}, {0, 0, 1}}
+
+// Computes the attitude from one accelerometer and one magnetometer reading
+// with the TRIAD method. Gravity is trusted fully and the magnetic field is
+// only used for the heading, so magnetic inclination doesn't matter. The
+// readings should be calibrated, and the device shouldn't be accelerating.
+func TriadAttitude(axes CompassAxes, xa, ya, za physic.Force, xm, ym, zm MagneticFluxDensity, frame Frame) (RotationMatrix, error) {
+	err := axes.Check()
+	if err != nil {
+		return RotationMatrix{}, err
+	}
+	acceleration := axes.ToBody(float64(xa), float64(ya), float64(za))
+	field := axes.ToBody(float64(xm), float64(ym), float64(zm))
+	matrix, err := TriadBody(acceleration, field)
+	if err != nil {
+		return RotationMatrix{}, err
+	}
+	return ConvertFrame(matrix, FRAME_NED, frame), nil
+}
+
+// Like TriadAttitude, but for readings that are already in forward, right,
+// down body axes, in any units. Returns the rotation from body to NED.
+func TriadBody(acceleration, field [3]float64) (RotationMatrix, error) {
+	// The world axes in body coordinates. At rest, the accelerometer measures
+	// the reaction to gravity, which is up.
+	down, ok := Normalize([3]float64{-acceleration[0], -acceleration[1], -acceleration[2]})
+	if !ok {
+		return RotationMatrix{}, errors.New("No acceleration, so tilt is unknown")
+	}
+	east, ok := Normalize(Cross(down, field))
+	if !ok {
+		return RotationMatrix{}, errors.New("No horizontal magnetic field, so heading is unknown")
+	}
+	north := Cross(east, down)
+
+	// The rows are the world axes, so this maps body to world
+	return RotationMatrix{north, east, down}, nil
+}
+
+// Returns the cross product a × b
+func Cross(a, b [3]float64) [3]float64 {
+	return [3]float64{
+		a[1]*b[2] - a[2]*b[1],
+		a[2]*b[0] - a[0]*b[2],
+		a[0]*b[1] - a[1]*b[0],
+	}
+}
+
+// Scales the vector to unit length. Returns false if it has no length.
+func Normalize(vector [3]float64) ([3]float64, bool) {
+	length := math.Sqrt(vector[0]*vector[0] + vector[1]*vector[1] + vector[2]*vector[2])
+	if length == 0 {
+		return vector, false
+	}
+	return [3]float64{vector[0] / length, vector[1] / length, vector[2] / length}, true
+}
+
+// NED and ENU differ by fixed rotations of both the world and body axes
+var (
+	nedToEnuWorld = RotationMatrix{{0, 1, 0}, {1, 0, 0}, {0, 0, -1}}
+	nedToEnuBody  = RotationMatrix{{1, 0, 0}, {0, -1, 0}, {0, 0, -1}}
+)
+
+// Re-expresses a rotation in another frame
+func ConvertFrame(matrix RotationMatrix, from, to Frame) RotationMatrix {
+	if from == to {
+		return matrix
+	}
+	if from == FRAME_NED {
+		// world_enu = W * world_ned = W * R * body_ned = W * R * B' * body_enu
+		return nedToEnuWorld.Multiply(matrix).Multiply(nedToEnuBody.Transpose())
+	}
+	return nedToEnuWorld.Transpose().Multiply(matrix).Multiply(nedToEnuBody)
+}
+
+func (matrix RotationMatrix) Multiply(other RotationMatrix) RotationMatrix {
+	var result RotationMatrix
+	for i := range result {
+		for j := range result[i] {
+			for k := range other {
+				result[i][j] += matrix[i][k] * other[k][j]
+			}
+		}
+	}
+	return result
+}
+
+// The inverse rotation, from world to body
+func (matrix RotationMatrix) Transpose() RotationMatrix {
+	var result RotationMatrix
+	for i := range result {
+		for j := range result[i] {
+			result[i][j] = matrix[j][i]
+		}
+	}
+	return result
+}
+
+// Rotates a vector from body axes to world axes
+func (matrix RotationMatrix) Rotate(vector [3]float64) [3]float64 {
+	return multiply3(matrix, vector)
+}
+
+func (matrix RotationMatrix) Quaternion() Quaternion {
+	m := matrix
+	var quaternion Quaternion
+	// Use the largest of the diagonal terms to avoid dividing by a small
+	// number
+	trace := m[0][0] + m[1][1] + m[2][2]
+	switch {
+	case trace > 0:
+		s := 2 * math.Sqrt(1+trace)
+		quaternion = Quaternion{s / 4, (m[2][1] - m[1][2]) / s, (m[0][2] - m[2][0]) / s, (m[1][0] - m[0][1]) / s}
+	case m[0][0] > m[1][1] && m[0][0] > m[2][2]:
+		s := 2 * math.Sqrt(1+m[0][0]-m[1][1]-m[2][2])
+		quaternion = Quaternion{(m[2][1] - m[1][2]) / s, s / 4, (m[0][1] + m[1][0]) / s, (m[0][2] + m[2][0]) / s}
+	case m[1][1] > m[2][2]:
+		s := 2 * math.Sqrt(1+m[1][1]-m[0][0]-m[2][2])
+		quaternion = Quaternion{(m[0][2] - m[2][0]) / s, (m[0][1] + m[1][0]) / s, s / 4, (m[1][2] + m[2][1]) / s}
+	default:
+		s := 2 * math.Sqrt(1+m[2][2]-m[0][0]-m[1][1])
+		quaternion = Quaternion{(m[1][0] - m[0][1]) / s, (m[0][2] + m[2][0]) / s, (m[1][2] + m[2][1]) / s, s / 4}
+	}
+	// q and -q are the same rotation, so pick the one with a positive scalar
+	if quaternion.W < 0 {
+		quaternion = Quaternion{-quaternion.W, -quaternion.X, -quaternion.Y, -quaternion.Z}
+	}
+	return quaternion.Normalize()
+}
+
+// EulerAngles treats the matrix as gimbal locked when the sine of the pitch,
+// -m[2][0], is this close to ±1. The matrix is already orthonormal, so only
+// rounding needs to be allowed for, unlike the accelerometer based check in
+// ComputeAttitude.
+const eulerGimbalLockLimit = 1 - 1e-9
+
+func (matrix RotationMatrix) EulerAngles() EulerAngles {
+	m := matrix
+	sinPitch := math.Max(-1, math.Min(1, -m[2][0]))
+	pitch := math.Asin(sinPitch)
+	if math.Abs(sinPitch) > eulerGimbalLockLimit {
+		return EulerAngles{
+			Pitch: toAngle(pitch),
+			Yaw:   toAngle(math.Atan2(-m[0][1], m[1][1])),
+		}
+	}
+	return EulerAngles{
+		Roll:  toAngle(math.Atan2(m[2][1], m[2][2])),
+		Pitch: toAngle(pitch),
+		Yaw:   toAngle(math.Atan2(m[1][0], m[0][0])),
+	}
+}
+
+func (angles EulerAngles) Matrix() RotationMatrix {
+	sinRoll, cosRoll := math.Sincos(float64(angles.Roll) / float64(physic.Radian))
+	sinPitch, cosPitch := math.Sincos(float64(angles.Pitch) / float64(physic.Radian))
+	sinYaw, cosYaw := math.Sincos(float64(angles.Yaw) / float64(physic.Radian))
+	return RotationMatrix{
+		{cosYaw * cosPitch, cosYaw*sinPitch*sinRoll - sinYaw*cosRoll, cosYaw*sinPitch*cosRoll + sinYaw*sinRoll},
+		{sinYaw * cosPitch, sinYaw*sinPitch*sinRoll + cosYaw*cosRoll, sinYaw*sinPitch*cosRoll - cosYaw*sinRoll},
+		{-sinPitch, cosPitch * sinRoll, cosPitch * cosRoll},
+	}
+}
+
+func (angles EulerAngles) Quaternion() Quaternion {
+	return angles.Matrix().Quaternion()
+}
+
+func (quaternion Quaternion) Matrix() RotationMatrix {
+	q := quaternion.Normalize()
+	return RotationMatrix{
+		{1 - 2*(q.Y*q.Y+q.Z*q.Z), 2 * (q.X*q.Y - q.W*q.Z), 2 * (q.X*q.Z + q.W*q.Y)},
+		{2 * (q.X*q.Y + q.W*q.Z), 1 - 2*(q.X*q.X+q.Z*q.Z), 2 * (q.Y*q.Z - q.W*q.X)},
+		{2 * (q.X*q.Z - q.W*q.Y), 2 * (q.Y*q.Z + q.W*q.X), 1 - 2*(q.X*q.X+q.Y*q.Y)},
+	}
+}
+
+func (quaternion Quaternion) EulerAngles() EulerAngles {
+	return quaternion.Matrix().EulerAngles()
+}
+
+// Combines rotations, so that the result applies other first and then this
+func (quaternion Quaternion) Multiply(other Quaternion) Quaternion {
+	a := quaternion
+	b := other
+	return Quaternion{
+		W: a.W*b.W - a.X*b.X - a.Y*b.Y - a.Z*b.Z,
+		X: a.W*b.X + a.X*b.W + a.Y*b.Z - a.Z*b.Y,
+		Y: a.W*b.Y - a.X*b.Z + a.Y*b.W + a.Z*b.X,
+		Z: a.W*b.Z + a.X*b.Y - a.Y*b.X + a.Z*b.W,
+	}
+}
+
+// The inverse rotation, from world to body
+func (quaternion Quaternion) Conjugate() Quaternion {
+	return Quaternion{quaternion.W, -quaternion.X, -quaternion.Y, -quaternion.Z}
+}
+
+func (quaternion Quaternion) Normalize() Quaternion {
+	length := math.Sqrt(quaternion.W*quaternion.W + quaternion.X*quaternion.X + quaternion.Y*quaternion.Y + quaternion.Z*quaternion.Z)
+	if length == 0 {
+		return Quaternion{W: 1}
+	}
+	return Quaternion{quaternion.W / length, quaternion.X / length, quaternion.Y / length, quaternion.Z / length}
+}
+
+// Rotates a vector from body axes to world axes
+func (quaternion Quaternion) Rotate(vector [3]float64) [3]float64 {
+	quaternion = quaternion.Normalize()
+	rotated := quaternion.Multiply(Quaternion{0, vector[0], vector[1], vector[2]}).Multiply(quaternion.Conjugate())
+	return [3]float64{rotated.X, rotated.Y, rotated.Z}
+}
@@ -0,0 +1,142 @@
+package lsm303
+
+import (
+	"math"
+	"periph.io/x/periph/conn/physic"
+	"testing"
+)
+
+func degrees(roll, pitch, yaw float64) EulerAngles {
+	return EulerAngles{
+		Roll:  physic.Angle(roll * float64(physic.Degree)),
+		Pitch: physic.Angle(pitch * float64(physic.Degree)),
+		Yaw:   physic.Angle(yaw * float64(physic.Degree)),
+	}
+}
+
+func checkEuler(t *testing.T, name string, angles EulerAngles, expected EulerAngles) {
+	t.Helper()
+	if angleDifference(angles.Roll, float64(expected.Roll)/float64(physic.Degree)) > 0.01 ||
+		angleDifference(angles.Pitch, float64(expected.Pitch)/float64(physic.Degree)) > 0.01 ||
+		angleDifference(angles.Yaw, float64(expected.Yaw)/float64(physic.Degree)) > 0.01 {
+		t.Errorf("%s: expected %v but was %v", name, expected, angles)
+	}
+}
+
+func TestEulerConversions(t *testing.T) {
+	tests := []struct {
+		name     string
+		angles   EulerAngles
+		expected EulerAngles
+	}{
+		{"identity", degrees(0, 0, 0), degrees(0, 0, 0)},
+		{"yaw", degrees(0, 0, 135), degrees(0, 0, 135)},
+		{"negative yaw", degrees(0, 0, -100), degrees(0, 0, -100)},
+		{"all", degrees(-30, 40, 170), degrees(-30, 40, 170)},
+		{"upside down", degrees(180, 10, -20), degrees(180, 10, -20)},
+		{"nearly vertical", degrees(25, 89.9, 60), degrees(25, 89.9, 60)},
+		// Roll and yaw can't be told apart, so roll is folded into yaw
+		{"vertical", degrees(20, 90, 60), degrees(0, 90, 40)},
+		{"vertical down", degrees(20, -90, 60), degrees(0, -90, 80)},
+	}
+	for _, test := range tests {
+		matrix := test.angles.Matrix()
+		checkEuler(t, test.name+" matrix", matrix.EulerAngles(), test.expected)
+		quaternion := test.angles.Quaternion()
+		checkEuler(t, test.name+" quaternion", quaternion.EulerAngles(), test.expected)
+
+		// All of the representations rotate vectors the same way
+		vector := [3]float64{0.3, -1.2, 2.5}
+		fromMatrix := matrix.Rotate(vector)
+		fromQuaternion := quaternion.Rotate(vector)
+		fromBoth := quaternion.Matrix().Rotate(vector)
+		for i := range vector {
+			if math.Abs(fromMatrix[i]-fromQuaternion[i]) > 1e-9 || math.Abs(fromMatrix[i]-fromBoth[i]) > 1e-9 {
+				t.Errorf("%s: rotations differ %v %v %v", test.name, fromMatrix, fromQuaternion, fromBoth)
+				break
+			}
+		}
+	}
+}
+
+func TestQuaternionMultiply(t *testing.T) {
+	first := degrees(10, 0, 0).Quaternion()
+	second := degrees(0, 0, 30).Quaternion()
+	combined := second.Multiply(first).Matrix()
+	expected := second.Matrix().Multiply(first.Matrix())
+	for i := range combined {
+		for j := range combined[i] {
+			if math.Abs(combined[i][j]-expected[i][j]) > 1e-9 {
+				t.Fatalf("Expected %v but was %v", expected, combined)
+			}
+		}
+	}
+	identity := first.Multiply(first.Conjugate())
+	if math.Abs(identity.W-1) > 1e-9 {
+		t.Fatalf("Expected the identity but was %v", identity)
+	}
+}
+
+func TestConvertFrame(t *testing.T) {
+	tests := []struct {
+		name     string
+		ned, enu EulerAngles
+	}{
+		{"north", degrees(0, 0, 0), degrees(0, 0, 90)},
+		{"east", degrees(0, 0, 90), degrees(0, 0, 0)},
+		{"west", degrees(0, 0, -90), degrees(0, 0, 180)},
+		// The body's Y axis points left in ENU, so pitch flips
+		{"nose up", degrees(0, 30, 0), degrees(0, -30, 90)},
+		{"right side down", degrees(20, 0, 45), degrees(20, 0, 45)},
+	}
+	for _, test := range tests {
+		enu := ConvertFrame(test.ned.Matrix(), FRAME_NED, FRAME_ENU)
+		checkEuler(t, test.name+" to ENU", enu.EulerAngles(), test.enu)
+		ned := ConvertFrame(enu, FRAME_ENU, FRAME_NED)
+		checkEuler(t, test.name+" to NED", ned.EulerAngles(), test.ned)
+	}
+}
+
+func TestTriadAttitude(t *testing.T) {
+	tests := []struct {
+		name                 string
+		axes                 CompassAxes
+		pitch, roll, heading float64
+	}{
+		{"level", DefaultCompassAxes, 0, 0, 0},
+		{"banked", DefaultCompassAxes, 10, -60, 200},
+		{"upside down", DefaultCompassAxes, 5, 180, 100},
+		{"Y forward", YForwardCompassAxes, 15, 25, 300},
+	}
+	for _, test := range tests {
+		acceleration, field := simulateAttitude(test.axes, test.pitch, test.roll, test.heading)
+		matrix, err := TriadAttitude(test.axes, acceleration[0], acceleration[1], acceleration[2], field[0], field[1], field[2], FRAME_NED)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		checkEuler(t, test.name, matrix.EulerAngles(), degrees(test.roll, test.pitch, test.heading))
+
+		// The reaction to gravity points up in the world frame
+		body := test.axes.ToBody(float64(acceleration[0]), float64(acceleration[1]), float64(acceleration[2]))
+		world := matrix.Rotate(body)
+		if math.Abs(world[2]+float64(physic.EarthGravity)) > 1e-3*float64(physic.EarthGravity) {
+			t.Errorf("%s: acceleration should be up in the world frame but was %v", test.name, world)
+		}
+
+		enu, err := TriadAttitude(test.axes, acceleration[0], acceleration[1], acceleration[2], field[0], field[1], field[2], FRAME_ENU)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkEuler(t, test.name+" ENU", enu.EulerAngles(), ConvertFrame(matrix, FRAME_NED, FRAME_ENU).EulerAngles())
+	}
+
+	_, err := TriadAttitude(DefaultCompassAxes, 0, 0, 0, Gauss, 0, 0, FRAME_NED)
+	if err == nil {
+		t.Fatal("No acceleration should fail")
+	}
+	_, err = TriadAttitude(DefaultCompassAxes, 0, 0, physic.EarthGravity, 0, 0, Gauss, FRAME_NED)
+	if err == nil {
+		t.Fatal("Vertical field should fail")
+	}
+}
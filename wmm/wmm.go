@@ -0,0 +1,306 @@
+// Package wmm implements the World Magnetic Model, which gives the direction
+// and strength of the Earth's magnetic field anywhere on the planet. It's
+// used to convert magnetic headings to true headings.
+//
+// The coefficients aren't included. Download the WMM.COF file for the current
+// model from NOAA and load it with Load. The current model is WMM2025, which
+// is valid from 2025 through 2029. WMM2020, which the tests use, expired at
+// the end of 2024.
+package wmm
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	lsm303 "github.com/bskari/go-lsm303"
+	"io"
+	"math"
+	"os"
+	"periph.io/x/periph/conn/physic"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Each model is only valid for this many years after its epoch
+const VALIDITY_YEARS = 5
+
+// Returned with the field when the date is outside of the model's validity
+var ErrOutsideValidity = errors.New("Date is outside of the magnetic model's validity")
+
+const (
+	// WGS 84 ellipsoid, in kilometers
+	semiMajorAxis = 6378.137
+	flattening    = 1 / 298.257223563
+	// The geomagnetic reference radius, in kilometers
+	referenceRadius = 6371.2
+)
+
+// The spherical harmonic coefficients from a WMM.COF file
+type Model struct {
+	// e.g. "WMM-2020"
+	Name string
+	// The decimal year that the coefficients are for
+	Epoch float64
+	// The highest degree of the coefficients
+	MaxDegree int
+	// Indexed by degree and order, in nanotesla and nanotesla per year
+	g, h, gDot, hDot [][]float64
+}
+
+func Load(path string) (*Model, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return Read(file)
+}
+
+// Reads coefficients in the WMM.COF format
+func Read(reader io.Reader) (*Model, error) {
+	scanner := bufio.NewScanner(reader)
+	if !scanner.Scan() {
+		if scanner.Err() != nil {
+			return nil, scanner.Err()
+		}
+		return nil, errors.New("Empty magnetic model file")
+	}
+	header := strings.Fields(scanner.Text())
+	if len(header) < 2 {
+		return nil, errors.New("Bad magnetic model header")
+	}
+	epoch, err := strconv.ParseFloat(header[0], 64)
+	if err != nil {
+		return nil, errors.New("Bad magnetic model epoch " + header[0])
+	}
+
+	type coefficient struct {
+		n, m             int
+		g, h, gDot, hDot float64
+	}
+	var coefficients []coefficient
+	maxDegree := 0
+	terminated := false
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "9999") {
+			terminated = true
+			break
+		}
+		if line == "" {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 6 {
+			return nil, errors.New("Bad magnetic model line " + line)
+		}
+		var values [6]float64
+		for i, field := range fields {
+			values[i], err = strconv.ParseFloat(field, 64)
+			if err != nil {
+				return nil, errors.New("Bad magnetic model line " + line)
+			}
+		}
+		c := coefficient{int(values[0]), int(values[1]), values[2], values[3], values[4], values[5]}
+		if c.n < 1 || c.m < 0 || c.m > c.n {
+			return nil, errors.New("Bad magnetic model degree and order in " + line)
+		}
+		if c.n > maxDegree {
+			maxDegree = c.n
+		}
+		coefficients = append(coefficients, c)
+	}
+	if scanner.Err() != nil {
+		return nil, scanner.Err()
+	}
+	if !terminated || len(coefficients) == 0 {
+		return nil, errors.New("Magnetic model file is truncated")
+	}
+
+	model := &Model{
+		Name:      header[1],
+		Epoch:     epoch,
+		MaxDegree: maxDegree,
+		g:         triangle(maxDegree),
+		h:         triangle(maxDegree),
+		gDot:      triangle(maxDegree),
+		hDot:      triangle(maxDegree),
+	}
+	for _, c := range coefficients {
+		model.g[c.n][c.m] = c.g
+		model.h[c.n][c.m] = c.h
+		model.gDot[c.n][c.m] = c.gDot
+		model.hDot[c.n][c.m] = c.hDot
+	}
+	return model, nil
+}
+
+func triangle(maxDegree int) [][]float64 {
+	result := make([][]float64, maxDegree+1)
+	for n := range result {
+		result[n] = make([]float64, n+1)
+	}
+	return result
+}
+
+// The Earth's magnetic field at a location
+type Field struct {
+	// The angle from true north to magnetic north, positive to the east
+	Declination physic.Angle
+	// The angle of the field below horizontal, positive pointing down
+	Inclination physic.Angle
+	// The total strength of the field. A calibrated magnetometer should read
+	// about this much.
+	Total      lsm303.MagneticFluxDensity
+	Horizontal lsm303.MagneticFluxDensity
+	North      lsm303.MagneticFluxDensity
+	East       lsm303.MagneticFluxDensity
+	Down       lsm303.MagneticFluxDensity
+}
+
+// Computes the field at a location. The altitude is above the WGS 84
+// ellipsoid, which is what GPS reports. If the date is outside of the model's
+// validity, the field is still computed but ErrOutsideValidity is returned
+// with it.
+func (model *Model) Field(latitude, longitude physic.Angle, altitude physic.Distance, date time.Time) (Field, error) {
+	if latitude < -90*physic.Degree || latitude > 90*physic.Degree {
+		return Field{}, errors.New("Latitude must be between -90 and 90 degrees")
+	}
+	year := decimalYear(date)
+	north, east, down := model.fieldAt(
+		float64(latitude)/float64(physic.Radian),
+		float64(longitude)/float64(physic.Radian),
+		float64(altitude)/float64(physic.KiloMetre),
+		year,
+	)
+	horizontal := math.Hypot(north, east)
+	field := Field{
+		Declination: toAngle(math.Atan2(east, north)),
+		Inclination: toAngle(math.Atan2(down, horizontal)),
+		Total:       toFlux(math.Hypot(horizontal, down)),
+		Horizontal:  toFlux(horizontal),
+		North:       toFlux(north),
+		East:        toFlux(east),
+		Down:        toFlux(down),
+	}
+	if year < model.Epoch || year > model.Epoch+VALIDITY_YEARS {
+		return field, fmt.Errorf("%w: %s is valid from %.1f to %.1f", ErrOutsideValidity, model.Name, model.Epoch, model.Epoch+VALIDITY_YEARS)
+	}
+	return field, nil
+}
+
+// Returns the north, east and down components in nanotesla. Angles are in
+// radians and the altitude is in kilometers.
+func (model *Model) fieldAt(latitude, longitude, altitude, year float64) (float64, float64, float64) {
+	// Convert from geodetic to geocentric coordinates
+	eccentricity2 := flattening * (2 - flattening)
+	sinLatitude, cosLatitude := math.Sincos(latitude)
+	curvature := semiMajorAxis / math.Sqrt(1-eccentricity2*sinLatitude*sinLatitude)
+	p := (curvature + altitude) * cosLatitude
+	z := (curvature*(1-eccentricity2) + altitude) * sinLatitude
+	radius := math.Hypot(p, z)
+	geocentric := math.Asin(z / radius)
+
+	// The Legendre functions are in terms of colatitude
+	cosTheta := math.Sin(geocentric)
+	sinTheta := math.Cos(geocentric)
+	// Avoid dividing by zero at the poles, where declination isn't meaningful
+	// anyway
+	if sinTheta < 1e-10 {
+		sinTheta = 1e-10
+	}
+	legendre, derivative := schmidtLegendre(model.MaxDegree, cosTheta, sinTheta)
+
+	elapsed := year - model.Epoch
+	var x, y, zDown float64
+	for n := 1; n <= model.MaxDegree; n++ {
+		ratio := math.Pow(referenceRadius/radius, float64(n+2))
+		for m := 0; m <= n; m++ {
+			g := model.g[n][m] + elapsed*model.gDot[n][m]
+			h := model.h[n][m] + elapsed*model.hDot[n][m]
+			sinM, cosM := math.Sincos(float64(m) * longitude)
+			// The derivative is with respect to colatitude, which is the
+			// negative of the derivative with respect to latitude
+			x += ratio * (g*cosM + h*sinM) * derivative[n][m]
+			y += ratio * float64(m) * (g*sinM - h*cosM) * legendre[n][m]
+			zDown -= ratio * float64(n+1) * (g*cosM + h*sinM) * legendre[n][m]
+		}
+	}
+	y /= sinTheta
+
+	// Rotate back to geodetic coordinates
+	sinDifference, cosDifference := math.Sincos(geocentric - latitude)
+	north := x*cosDifference - zDown*sinDifference
+	down := x*sinDifference + zDown*cosDifference
+	return north, y, down
+}
+
+// Computes the Schmidt semi-normalized associated Legendre functions and their
+// derivatives with respect to colatitude. These are computed with the Gauss
+// normalized recursion and then scaled.
+func schmidtLegendre(maxDegree int, cosTheta, sinTheta float64) ([][]float64, [][]float64) {
+	legendre := triangle(maxDegree)
+	derivative := triangle(maxDegree)
+	legendre[0][0] = 1
+	for n := 1; n <= maxDegree; n++ {
+		for m := 0; m <= n; m++ {
+			if n == m {
+				legendre[n][m] = sinTheta * legendre[n-1][m-1]
+				derivative[n][m] = sinTheta*derivative[n-1][m-1] + cosTheta*legendre[n-1][m-1]
+				continue
+			}
+			legendre[n][m] = cosTheta * legendre[n-1][m]
+			derivative[n][m] = cosTheta*derivative[n-1][m] - sinTheta*legendre[n-1][m]
+			if n > 1 && m <= n-2 {
+				k := float64((n-1)*(n-1)-m*m) / float64((2*n-1)*(2*n-3))
+				legendre[n][m] -= k * legendre[n-2][m]
+				derivative[n][m] -= k * derivative[n-2][m]
+			}
+		}
+	}
+
+	scale := 1.0
+	for n := 1; n <= maxDegree; n++ {
+		scale *= float64(2*n-1) / float64(n)
+		factor := scale
+		for m := 0; m <= n; m++ {
+			if m > 0 {
+				double := 1.0
+				if m == 1 {
+					double = 2
+				}
+				factor *= math.Sqrt(float64(n-m+1) * double / float64(n+m))
+			}
+			legendre[n][m] *= factor
+			derivative[n][m] *= factor
+		}
+	}
+	return legendre, derivative
+}
+
+// Converts the date to a year with a fraction, e.g. 2020.5
+func decimalYear(date time.Time) float64 {
+	date = date.UTC()
+	start := time.Date(date.Year(), 1, 1, 0, 0, 0, 0, time.UTC)
+	end := time.Date(date.Year()+1, 1, 1, 0, 0, 0, 0, time.UTC)
+	return float64(date.Year()) + float64(date.Sub(start))/float64(end.Sub(start))
+}
+
+// Converts a magnetic heading, such as from lsm303.Compass, to a true heading
+// using the declination from Field
+func TrueHeading(magneticHeading, declination physic.Angle) physic.Angle {
+	heading := (magneticHeading + declination) % physic.Theta
+	if heading < 0 {
+		heading += physic.Theta
+	}
+	return heading
+}
+
+func toAngle(radians float64) physic.Angle {
+	return physic.Angle(math.Round(radians * float64(physic.Radian)))
+}
+
+func toFlux(nanotesla float64) lsm303.MagneticFluxDensity {
+	return lsm303.MagneticFluxDensity(math.Round(nanotesla))
+}
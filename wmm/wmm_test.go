@@ -0,0 +1,148 @@
+package wmm
+
+import (
+	"bufio"
+	"errors"
+	"math"
+	"os"
+	"periph.io/x/periph/conn/physic"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func loadTestModel(t *testing.T) *Model {
+	model, err := Load("testdata/WMM2020.COF")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return model
+}
+
+// Checks against the test values published with the model
+func TestFieldTestValues(t *testing.T) {
+	model := loadTestModel(t)
+	if model.Name != "WMM-2020" || model.Epoch != 2020 || model.MaxDegree != 12 {
+		t.Fatalf("Bad model %v %v %v", model.Name, model.Epoch, model.MaxDegree)
+	}
+
+	file, err := os.Open("testdata/WMM2020_TEST_VALUES.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	count := 0
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		var values []float64
+		for _, field := range strings.Fields(line) {
+			value, err := strconv.ParseFloat(field, 64)
+			if err != nil {
+				t.Fatal(err)
+			}
+			values = append(values, value)
+		}
+		year, altitude, latitude, longitude := values[0], values[1], values[2], values[3]
+		north, east, down := model.fieldAt(latitude*math.Pi/180, longitude*math.Pi/180, altitude, year)
+
+		declination := math.Atan2(east, north) * 180 / math.Pi
+		inclination := math.Atan2(down, math.Hypot(north, east)) * 180 / math.Pi
+		total := math.Sqrt(north*north + east*east + down*down)
+		if math.Abs(declination-values[4]) > 0.006 || math.Abs(inclination-values[5]) > 0.006 {
+			t.Errorf("%v: expected D %v I %v but was %v %v", line, values[4], values[5], declination, inclination)
+		}
+		if math.Abs(north-values[7]) > 0.06 || math.Abs(east-values[8]) > 0.06 || math.Abs(down-values[9]) > 0.06 || math.Abs(total-values[10]) > 0.06 {
+			t.Errorf("%v: expected X %v Y %v Z %v F %v but was %v %v %v %v", line, values[7], values[8], values[9], values[10], north, east, down, total)
+		}
+		count++
+	}
+	if count == 0 {
+		t.Fatal("No test values")
+	}
+}
+
+func TestField(t *testing.T) {
+	model := loadTestModel(t)
+	// The first test value
+	date := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	field, err := model.Field(89*physic.Degree, -121*physic.Degree, 28*physic.KiloMetre, date)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(float64(field.Declination)/float64(physic.Degree)+112.41) > 0.01 {
+		t.Fatalf("Bad declination %v", field.Declination)
+	}
+	if math.Abs(float64(field.Total)-56102.7) > 1 {
+		t.Fatalf("Bad total %v", field.Total)
+	}
+
+	// Still computed, but flagged. WMM2020 expired at the end of 2024.
+	_, err = model.Field(0, 0, 0, time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC))
+	if !errors.Is(err, ErrOutsideValidity) {
+		t.Fatalf("Expected ErrOutsideValidity but was %v", err)
+	}
+
+	_, err = model.Field(91*physic.Degree, 0, 0, date)
+	if err == nil {
+		t.Fatal("Bad latitude should fail")
+	}
+}
+
+func TestRead(t *testing.T) {
+	tests := []struct {
+		file string
+		ok   bool
+	}{
+		{"    2020.0            WMM-2020        12/10/2019\n  1  0  -29404.5       0.0        6.7        0.0\n9999999999\n", true},
+		{"", false},
+		{"    2020.0            WMM-2020        12/10/2019\n  1  0  -29404.5       0.0        6.7        0.0\n", false},
+		{"    2020.0            WMM-2020        12/10/2019\n  1  2  -29404.5       0.0        6.7        0.0\n9999999999\n", false},
+		{"    2020.0            WMM-2020        12/10/2019\n  1  0  -29404.5       0.0        6.7\n9999999999\n", false},
+		{"    WMM-2020\n9999999999\n", false},
+	}
+	for _, test := range tests {
+		_, err := Read(strings.NewReader(test.file))
+		if (err == nil) != test.ok {
+			t.Errorf("Reading %q returned %v", test.file, err)
+		}
+	}
+}
+
+func TestDecimalYear(t *testing.T) {
+	tests := []struct {
+		date     time.Time
+		expected float64
+	}{
+		{time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC), 2020},
+		// 2020 is a leap year
+		{time.Date(2020, 7, 2, 0, 0, 0, 0, time.UTC), 2020.5},
+		{time.Date(2021, 7, 2, 12, 0, 0, 0, time.UTC), 2021.5},
+	}
+	for _, test := range tests {
+		year := decimalYear(test.date)
+		if math.Abs(year-test.expected) > 1e-9 {
+			t.Errorf("decimalYear(%v) should be %v but was %v", test.date, test.expected, year)
+		}
+	}
+}
+
+func TestTrueHeading(t *testing.T) {
+	tests := []struct {
+		magnetic, declination, expected physic.Angle
+	}{
+		{10 * physic.Degree, 5 * physic.Degree, 15 * physic.Degree},
+		{10 * physic.Degree, -15 * physic.Degree, physic.Theta - 5*physic.Degree},
+		{350 * physic.Degree, 20 * physic.Degree, 370*physic.Degree - physic.Theta},
+	}
+	for _, test := range tests {
+		heading := TrueHeading(test.magnetic, test.declination)
+		if heading != test.expected {
+			t.Errorf("TrueHeading(%v, %v) should be %v but was %v", test.magnetic, test.declination, test.expected, heading)
+		}
+	}
+}